@@ -0,0 +1,127 @@
+package wurfl
+
+//
+//#include <wurfl/wurfl.h>
+import "C"
+
+import (
+	"net/http"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// A header lookup passes every important header value to libwurfl as a C string. Instead of
+// a malloc/free pair per value, the values are copied one after the other into a single C
+// buffer, the headerArena, taken from a pool for the lookup and given back afterwards; the
+// C strings passed to wurfl_important_header_set point into it. The buffer is allocated
+// with cMalloc: with the wurfl_audit build tag, arenas are not pooled and their buffer is
+// freed on release, so that a value used after its lookup reads the poison.
+
+const (
+	// minArenaSize is the initial size of an arena, enough for the headers of most requests
+	minArenaSize = 4096
+	// maxPooledArenaSize is the size above which an arena grown by a huge request is freed
+	// instead of being pooled
+	maxPooledArenaSize = 64 * 1024
+)
+
+// headerArena is a C buffer holding the header values of a lookup, see getHeaderArena
+type headerArena struct {
+	buf  *C.char
+	size int
+	used int
+}
+
+var headerArenaPool = sync.Pool{
+	New: func() any {
+		a := &headerArena{}
+		// an arena dropped by the pool frees its C buffer
+		runtime.SetFinalizer(a, (*headerArena).free)
+		return a
+	},
+}
+
+// getHeaderArena returns an empty arena, to be released once the values are no longer used
+func getHeaderArena() *headerArena {
+	return headerArenaPool.Get().(*headerArena)
+}
+
+// release empties a and gives it back to the pool
+func (a *headerArena) release() {
+	if a.size > maxPooledArenaSize || auditing {
+		a.free()
+	}
+	a.used = 0
+	headerArenaPool.Put(a)
+}
+
+func (a *headerArena) free() {
+	if a.buf != nil {
+		cFree(a.buf)
+	}
+	a.buf = nil
+	a.size = 0
+	a.used = 0
+}
+
+// alloc returns n bytes of the arena. When they do not fit, the buffer is replaced by a larger
+// one: the C strings of the arena are only valid until the next alloc, which is enough as
+// wurfl_important_header_set copies the value it is passed.
+func (a *headerArena) alloc(n int) []byte {
+	if a.used+n > a.size {
+		size := max(minArenaSize, 2*a.size)
+		for size < n {
+			size *= 2
+		}
+		if a.buf != nil {
+			cFree(a.buf)
+		}
+		a.buf = cMalloc(size)
+		a.size = size
+		a.used = 0
+	}
+	b := unsafe.Slice((*byte)(unsafe.Add(unsafe.Pointer(a.buf), a.used)), n)
+	a.used += n
+	return b
+}
+
+// cString copies s to the arena as a C string
+func (a *headerArena) cString(s string) *C.char {
+	b := a.alloc(len(s) + 1)
+	copy(b, s)
+	b[len(s)] = 0
+	return (*C.char)(unsafe.Pointer(&b[0]))
+}
+
+// cStringBytes copies v to the arena as a C string
+func (a *headerArena) cStringBytes(v []byte) *C.char {
+	b := a.alloc(len(v) + 1)
+	copy(b, v)
+	b[len(v)] = 0
+	return (*C.char)(unsafe.Pointer(&b[0]))
+}
+
+// setRequestHeader fills cih with the first value of each important header of h, as
+// http.Header.Get returns it
+func (hs *headerSet) setRequestHeader(cih C.wurfl_important_header_handle, h http.Header) {
+	a := getHeaderArena()
+	defer a.release()
+	for i, name := range hs.names {
+		if value := h.Get(name); value != "" {
+			C.wurfl_important_header_set(cih, hs.cnames[i], a.cString(value))
+		}
+	}
+}
+
+// setHeaderMap fills cih with the important headers of IHMap, matching their names
+// case-insensitively with the trie
+func (hs *headerSet) setHeaderMap(cih C.wurfl_important_header_handle, IHMap map[string]string) {
+	a := getHeaderArena()
+	defer a.release()
+	for name, value := range IHMap {
+		if cname, found := hs.trie.get(name); found {
+			C.wurfl_important_header_set(cih, cname, a.cString(value))
+		}
+	}
+}
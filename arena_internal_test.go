@@ -0,0 +1,59 @@
+package wurfl
+
+import (
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// goString reads the NUL terminated C string at p, cgo is not available in tests
+func goString(p unsafe.Pointer) string {
+	var sb strings.Builder
+	for b := (*byte)(p); *b != 0; b = (*byte)(unsafe.Add(unsafe.Pointer(b), 1)) {
+		sb.WriteByte(*b)
+	}
+	return sb.String()
+}
+
+func TestHeaderArena(t *testing.T) {
+	a := getHeaderArena()
+	defer a.release()
+
+	values := []string{"Mozilla/5.0", "", `"Chromium";v="118"`, "?1"}
+	var cvalues []unsafe.Pointer
+	for _, v := range values {
+		cvalues = append(cvalues, unsafe.Pointer(a.cString(v)))
+	}
+	// the values are laid out one after the other in the same buffer
+	for i, v := range values {
+		assert.Equal(t, v, goString(cvalues[i]))
+		if i > 0 {
+			assert.Equal(t, uintptr(cvalues[i-1])+uintptr(len(values[i-1])+1),
+				uintptr(cvalues[i]))
+		}
+	}
+	assert.Equal(t, "abc", goString(unsafe.Pointer(a.cStringBytes([]byte("abc")))))
+}
+
+func TestHeaderArena_Grow(t *testing.T) {
+	a := getHeaderArena()
+	a.cString("User-Agent")
+
+	// larger than the arena: the buffer is replaced
+	large := strings.Repeat("x", 3*minArenaSize)
+	assert.Equal(t, large, goString(unsafe.Pointer(a.cString(large))))
+	assert.GreaterOrEqual(t, a.size, len(large)+1)
+	assert.Equal(t, "?1", goString(unsafe.Pointer(a.cString("?1"))))
+	a.release()
+	assert.Zero(t, a.used)
+
+	// an arena grown past maxPooledArenaSize is not kept in the pool
+	a = getHeaderArena()
+	huge := strings.Repeat("x", maxPooledArenaSize)
+	assert.Equal(t, huge, goString(unsafe.Pointer(a.cString(huge))))
+	a.release()
+	assert.Zero(t, a.size)
+	assert.Nil(t, a.buf)
+}
@@ -0,0 +1,162 @@
+package wurfl
+
+import (
+	"sort"
+)
+
+// Attr is an engine attribute, to be used with SetAttrValue, GetAttrValue and SetAttrs
+type Attr int
+
+// Engine attributes
+const (
+	// AttrExtraHeadersExperimental is deprecated since 1.12.5.0 and should not be used.
+	AttrExtraHeadersExperimental Attr = WurflAttrExtraHeadersExperimental
+	// AttrCapabilityFallbackCache controls the capability fallback cache (needs libwurfl version 1.12.9.3 or above)
+	AttrCapabilityFallbackCache Attr = WurflAttrCapabilityFallbackCache
+)
+
+// AttrValue is the value of an engine attribute
+type AttrValue int
+
+// AttrCapabilityFallbackCache values
+const (
+	CapabilityFallbackCacheDefault  AttrValue = WurflAttrCapabilityFallbackCacheDefault
+	CapabilityFallbackCacheDisabled AttrValue = WurflAttrCapabilityFallbackCacheDisabled
+	CapabilityFallbackCacheLimited  AttrValue = WurflAttrCapabilityFallbackCacheLimited
+)
+
+// knownAttrs lists every Attr, in the order Attrs and SetAttrs handle them
+var knownAttrs = []Attr{AttrExtraHeadersExperimental, AttrCapabilityFallbackCache}
+
+// AllAttrs returns every known engine attribute
+func AllAttrs() []Attr {
+	return append([]Attr(nil), knownAttrs...)
+}
+
+func (a Attr) String() string {
+	switch a {
+	case AttrExtraHeadersExperimental:
+		return "ExtraHeadersExperimental"
+	case AttrCapabilityFallbackCache:
+		return "CapabilityFallbackCache"
+	}
+	return "Unknown"
+}
+
+// Validate checks that v is an accepted value for the attribute, and that the linked
+// libwurfl supports it. It returns ErrInvalidParameter or ErrUnsupportedLibwurfl.
+func (a Attr) Validate(v AttrValue) error {
+	switch a {
+	case AttrExtraHeadersExperimental:
+		// any value is accepted, 0 disables it
+		return nil
+	case AttrCapabilityFallbackCache:
+		switch v {
+		case CapabilityFallbackCacheDefault, CapabilityFallbackCacheDisabled, CapabilityFallbackCacheLimited:
+		default:
+			return ErrInvalidParameter
+		}
+		return requireFeature(FeatureCapabilityFallbackCache)
+	}
+	return ErrInvalidParameter
+}
+
+// known reports whether a is one of the engine attributes
+func (a Attr) known() bool {
+	for _, k := range knownAttrs {
+		if a == k {
+			return true
+		}
+	}
+	return false
+}
+
+// supported reports whether the linked libwurfl knows the attribute
+func (a Attr) supported() bool {
+	return a != AttrCapabilityFallbackCache || requireFeature(FeatureCapabilityFallbackCache) == nil
+}
+
+// SetAttrValue sets an engine attribute, validating it first
+func (w *Wurfl) SetAttrValue(a Attr, v AttrValue) error {
+	return w.SetAttr(int(a), int(v))
+}
+
+// GetAttrValue returns the current value of an engine attribute
+func (w *Wurfl) GetAttrValue(a Attr) (AttrValue, error) {
+	if !a.known() {
+		return 0, ErrInvalidParameter
+	}
+	v, err := w.GetAttr(int(a))
+	return AttrValue(v), err
+}
+
+// Attrs returns the current value of every attribute supported by the linked libwurfl
+func (w *Wurfl) Attrs() (map[Attr]AttrValue, error) {
+	e := w.acquire()
+	if e == nil {
+		return nil, ErrEngineClosed
+	}
+	defer e.release()
+
+	return e.attrs()
+}
+
+// SetAttrs sets several attributes at once. All of them are validated first; if setting
+// one of them fails, the ones already set are restored to their previous value. Like
+// SetAttr, it changes the current engine in place: a lookup running meanwhile may see
+// some of the new values only.
+func (w *Wurfl) SetAttrs(values map[Attr]AttrValue) error {
+	attrs := make([]Attr, 0, len(values))
+	for a, v := range values {
+		if err := a.Validate(v); err != nil {
+			return err
+		}
+		attrs = append(attrs, a)
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i] < attrs[j] })
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	e := w.acquire()
+	if e == nil {
+		return ErrEngineClosed
+	}
+	defer e.release()
+
+	previous, err := e.attrs()
+	if err != nil {
+		return err
+	}
+
+	for i, a := range attrs {
+		if err := e.setAttr(int(a), int(values[a])); err != nil {
+			// roll back, in reverse order
+			for j := i - 1; j >= 0; j-- {
+				_ = e.setAttr(int(attrs[j]), int(previous[attrs[j]]))
+			}
+			return err
+		}
+	}
+
+	for _, a := range attrs {
+		w.opts.setAttr(int(a), int(values[a]))
+	}
+	return nil
+}
+
+// attrs returns the current value of every attribute supported by the linked libwurfl
+func (e *engine) attrs() (map[Attr]AttrValue, error) {
+	result := make(map[Attr]AttrValue, len(knownAttrs))
+	for _, a := range knownAttrs {
+		if !a.supported() {
+			continue
+		}
+		v, err := e.getAttr(int(a))
+		if err != nil {
+			return nil, err
+		}
+		result[a] = AttrValue(v)
+	}
+	return result, nil
+}
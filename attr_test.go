@@ -0,0 +1,54 @@
+package wurfl_test
+
+import (
+	"testing"
+
+	wurfl "github.com/WURFL/golang-wurfl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttr_Validate(t *testing.T) {
+	assert.NoError(t, wurfl.AttrExtraHeadersExperimental.Validate(1))
+	assert.ErrorIs(t, wurfl.AttrCapabilityFallbackCache.Validate(42), wurfl.ErrInvalidParameter)
+	assert.ErrorIs(t, wurfl.Attr(44).Validate(0), wurfl.ErrInvalidParameter)
+	assert.Equal(t, "CapabilityFallbackCache", wurfl.AttrCapabilityFallbackCache.String())
+	assert.Len(t, wurfl.AllAttrs(), 2)
+}
+
+func TestWurfl_Attrs(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+	require.NotNil(t, wengine)
+	defer wengine.Destroy()
+
+	err := wengine.SetAttrs(map[wurfl.Attr]wurfl.AttrValue{
+		wurfl.AttrCapabilityFallbackCache:  wurfl.CapabilityFallbackCacheLimited,
+		wurfl.AttrExtraHeadersExperimental: 0,
+	})
+	require.NoError(t, err)
+
+	attrs, err := wengine.Attrs()
+	require.NoError(t, err)
+	assert.Equal(t, wurfl.CapabilityFallbackCacheLimited, attrs[wurfl.AttrCapabilityFallbackCache])
+	assert.Equal(t, wurfl.AttrValue(0), attrs[wurfl.AttrExtraHeadersExperimental])
+
+	// an invalid value is rejected before anything is set
+	err = wengine.SetAttrs(map[wurfl.Attr]wurfl.AttrValue{
+		wurfl.AttrExtraHeadersExperimental: 1,
+		wurfl.AttrCapabilityFallbackCache:  42,
+	})
+	assert.ErrorIs(t, err, wurfl.ErrInvalidParameter)
+
+	value, err := wengine.GetAttrValue(wurfl.AttrExtraHeadersExperimental)
+	require.NoError(t, err)
+	assert.Equal(t, wurfl.AttrValue(0), value)
+
+	require.NoError(t, wengine.SetAttrValue(wurfl.AttrCapabilityFallbackCache, wurfl.CapabilityFallbackCacheDisabled))
+	value, err = wengine.GetAttrValue(wurfl.AttrCapabilityFallbackCache)
+	require.NoError(t, err)
+	assert.Equal(t, wurfl.CapabilityFallbackCacheDisabled, value)
+
+	_, err = wengine.GetAttrValue(44)
+	assert.ErrorIs(t, err, wurfl.ErrInvalidParameter)
+	assert.ErrorIs(t, wengine.SetAttr(wurfl.WurflAttrCapabilityFallbackCache, 42), wurfl.ErrInvalidParameter)
+}
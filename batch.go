@@ -0,0 +1,306 @@
+package wurfl
+
+//
+//#include <string.h>
+//#include <wurfl/wurfl.h>
+//
+// // wurfl_go_put copies s and its NUL to out at *used, it returns 0 when it does not fit
+// static int wurfl_go_put(char *out, size_t size, size_t *used, const char *s) {
+// 	size_t n = strlen(s) + 1;
+// 	if (*used + n > size) {
+// 		return 0;
+// 	}
+// 	memcpy(out + *used, s, n);
+// 	*used += n;
+// 	return 1;
+// }
+//
+// // wurfl_go_put_device copies the id of d then the values of caps to out, it returns the
+// // error of a capability, or -1 when they do not fit
+// static int wurfl_go_put_device(wurfl_device_handle d, const char **caps, const int *virt, int ncaps,
+// 		char *out, size_t size, size_t *used) {
+// 	size_t start = *used;
+// 	if (!wurfl_go_put(out, size, used, wurfl_device_get_id(d))) {
+// 		*used = start;
+// 		return -1;
+// 	}
+// 	for (int i = 0; i < ncaps; i++) {
+// 		wurfl_error err = WURFL_OK;
+// 		const char *value = virt[i] ? wurfl_device_get_virtual_cap(d, caps[i], &err)
+// 			: wurfl_device_get_static_cap(d, caps[i], &err);
+// 		if (err != WURFL_OK || value == NULL) {
+// 			*used = start;
+// 			return err != WURFL_OK ? err : WURFL_ERROR_UNKNOWN;
+// 		}
+// 		if (!wurfl_go_put(out, size, used, value)) {
+// 			*used = start;
+// 			return -1;
+// 		}
+// 	}
+// 	return WURFL_OK;
+// }
+//
+// // wurfl_go_batch_device stores the result of lookup i in out and errs[i], and destroys d;
+// // it returns 0 when the result does not fit
+// static int wurfl_go_batch_device(wurfl_handle h, wurfl_device_handle d, int i,
+// 		const char **caps, const int *virt, int ncaps, char *out, size_t size, size_t *used, int *errs) {
+// 	if (d == NULL) {
+// 		wurfl_error err = wurfl_get_error_code(h);
+// 		errs[i] = err != WURFL_OK ? err : WURFL_ERROR_UNKNOWN;
+// 		return 1;
+// 	}
+// 	int err = wurfl_go_put_device(d, caps, virt, ncaps, out, size, used);
+// 	wurfl_device_destroy(d);
+// 	if (err < 0) {
+// 		return 0;
+// 	}
+// 	errs[i] = err;
+// 	return 1;
+// }
+//
+// // wurfl_go_lookup_useragents looks up the n user agents starting at in + offsets[i]. It
+// // returns the number of lookups whose result fits in out.
+// static int wurfl_go_lookup_useragents(wurfl_handle h, const char *in, const int *offsets, int n,
+// 		const char **caps, const int *virt, int ncaps, char *out, size_t size, size_t *used, int *errs) {
+// 	for (int i = 0; i < n; i++) {
+// 		wurfl_device_handle d = wurfl_lookup_useragent(h, in + offsets[i]);
+// 		if (!wurfl_go_batch_device(h, d, i, caps, virt, ncaps, out, size, used, errs)) {
+// 			return i;
+// 		}
+// 	}
+// 	return n;
+// }
+//
+// // wurfl_go_lookup_header_maps looks up n sets of important headers: the counts[i] headers
+// // of set i follow those of set i - 1 in names, with their values at in + offsets[j]. It
+// // returns the number of lookups whose result fits in out.
+// static int wurfl_go_lookup_header_maps(wurfl_handle h, const char *in, const char **names, const int *offsets,
+// 		const int *counts, int n, const char **caps, const int *virt, int ncaps, char *out, size_t size,
+// 		size_t *used, int *errs) {
+// 	int first = 0;
+// 	for (int i = 0; i < n; i++) {
+// 		wurfl_device_handle d = NULL;
+// 		wurfl_important_header_handle ih = wurfl_important_header_create(h);
+// 		if (ih != NULL) {
+// 			for (int j = first; j < first + counts[i]; j++) {
+// 				wurfl_important_header_set(ih, names[j], in + offsets[j]);
+// 			}
+// 			d = wurfl_lookup_with_important_header(h, ih);
+// 			wurfl_important_header_destroy(ih);
+// 		}
+// 		if (!wurfl_go_batch_device(h, d, i, caps, virt, ncaps, out, size, used, errs)) {
+// 			return i;
+// 		}
+// 		first += counts[i];
+// 	}
+// 	return n;
+// }
+import "C"
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// A lookup through the Device API costs several cgo calls, the lookup, the device id, each
+// capability and the destruction of the Device, plus a C string for the user agent. A batch
+// lookup packs the user agents or the headers of every item in a single Go buffer and makes
+// one call to a C shim which runs the lookups, copies the device ids and the capability
+// values to a Go buffer and destroys the devices. When the results do not fit, the shim
+// stops and is called again with a larger buffer for the remaining items.
+
+// maxBatchBufferSize bounds the initial output buffer of a batch
+const maxBatchBufferSize = 1 << 20
+
+// batchItemSize is the output buffer size reserved per item, plus batchValueSize per
+// requested capability; the tests shrink them to make the buffer grow
+var (
+	batchItemSize  = 64
+	batchValueSize = 32
+)
+
+// BatchResult is the result of a lookup of a batch, see LookupUserAgents
+type BatchResult struct {
+	DeviceID string
+	// Capabilities are the values of the requested capabilities, static or virtual, by name
+	Capabilities map[string]string
+}
+
+// LookupUserAgents looks up each of uas, like LookupUserAgent, and returns the device id and
+// the values of the caps capabilities of the matched devices, at the index of their user
+// agent. A failed lookup, or capability, leaves its result empty and sets the error at its
+// index in the returned error slice, nil otherwise; an error failing the whole batch, such
+// as an unknown capability or ErrEngineClosed, is set at every index. The whole batch takes
+// a single slot of the lookup limiter, see WithMaxConcurrentLookups.
+func (w *Wurfl) LookupUserAgents(uas []string, caps []string) ([]BatchResult, []error) {
+	size := 0
+	for _, ua := range uas {
+		size += len(ua) + 1
+	}
+	in := make([]byte, 0, size)
+	offsets := make([]C.int, len(uas))
+	for i, ua := range uas {
+		offsets[i] = C.int(len(in))
+		in = append(in, ua...)
+		in = append(in, 0)
+	}
+
+	return w.lookupBatch(len(uas), caps, func(e *engine, b *batchCall, done, n int) int {
+		return int(C.wurfl_go_lookup_useragents(e.handle, cBuf(in), &offsets[done], C.int(n),
+			b.caps(), b.virt(), C.int(len(b.ccaps)-1), cBuf(b.out), C.size_t(len(b.out)), &b.used, &b.errs[done]))
+	})
+}
+
+// LookupHeaderMaps looks up each of IHMaps, like LookupWithImportantHeaderMap, and returns
+// the device id and the values of the caps capabilities of the matched devices, at the
+// index of their map. The errors are reported like LookupUserAgents does.
+func (w *Wurfl) LookupHeaderMaps(IHMaps []map[string]string, caps []string) ([]BatchResult, []error) {
+	// the important headers of map i are names[first[i]:first[i+1]], packed once the engine
+	// and its header names are known; the trailing nil keeps names addressable when empty
+	var in []byte
+	var names []*C.char
+	var offsets []C.int
+	first := make([]int, len(IHMaps)+1)
+	counts := make([]C.int, len(IHMaps))
+	pack := func(hs *headerSet) {
+		for i, IHMap := range IHMaps {
+			first[i] = len(names)
+			for name, value := range IHMap {
+				if cname, found := hs.trie.get(name); found {
+					names = append(names, cname)
+					offsets = append(offsets, C.int(len(in)))
+					in = append(in, value...)
+					in = append(in, 0)
+				}
+			}
+			counts[i] = C.int(len(names) - first[i])
+		}
+		first[len(IHMaps)] = len(names)
+		names = append(names, nil)
+		offsets = append(offsets, 0)
+		in = append(in, 0)
+	}
+
+	return w.lookupBatch(len(IHMaps), caps, func(e *engine, b *batchCall, done, n int) int {
+		if names == nil {
+			pack(e.headers.Load())
+		}
+		return int(C.wurfl_go_lookup_header_maps(e.handle, cBuf(in), &names[first[done]], &offsets[first[done]],
+			&counts[done], C.int(n), b.caps(), b.virt(), C.int(len(b.ccaps)-1), cBuf(b.out), C.size_t(len(b.out)),
+			&b.used, &b.errs[done]))
+	})
+}
+
+// batchCall holds the C arguments shared by the shim calls of a batch
+type batchCall struct {
+	ccaps []*C.char // requested capabilities, plus a trailing nil
+	kinds []C.int   // 1 for the virtual capabilities of ccaps
+	out   []byte    // results of the lookups
+	used  C.size_t  // bytes of out used by the last call
+	errs  []C.int   // wurfl_error of each item
+}
+
+func (b *batchCall) caps() **C.char {
+	return &b.ccaps[0]
+}
+
+func (b *batchCall) virt() *C.int {
+	return &b.kinds[0]
+}
+
+// cBuf returns the address of the first byte of buf, which must not be empty
+func cBuf(buf []byte) *C.char {
+	return (*C.char)(unsafe.Pointer(&buf[0]))
+}
+
+// batchFailed returns the errors of a batch of n items failed with err
+func batchFailed(n int, err error) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
+
+// lookupBatch runs the n lookups of a batch with call, which calls the shim for the n items
+// starting at done and returns how many of them it has looked up, until they are all done.
+// The C strings passed to the shim must come from e, which stays alive for the batch.
+func (w *Wurfl) lookupBatch(n int, caps []string, call func(e *engine, b *batchCall, done, n int) int) ([]BatchResult, []error) {
+	if n == 0 {
+		return []BatchResult{}, []error{}
+	}
+
+	if err := w.limiter.wait(context.Background()); err != nil {
+		return make([]BatchResult, n), batchFailed(n, err)
+	}
+	defer w.limiter.done()
+
+	e := w.acquire()
+	if e == nil {
+		return make([]BatchResult, n), batchFailed(n, ErrEngineClosed)
+	}
+	defer e.release()
+
+	b := &batchCall{
+		ccaps: make([]*C.char, 0, len(caps)+1),
+		kinds: make([]C.int, 0, len(caps)+1),
+		errs:  make([]C.int, n),
+	}
+	for _, cap := range caps {
+		ccap, found := e.capsCStringcache[cap]
+		if !found {
+			return make([]BatchResult, n), batchFailed(n, fmt.Errorf("%w: %q", ErrCapabilityNotFound, cap))
+		}
+		b.ccaps = append(b.ccaps, ccap)
+		kind := C.int(0)
+		if e.virtualCaps[cap] {
+			kind = 1
+		}
+		b.kinds = append(b.kinds, kind)
+	}
+	b.ccaps = append(b.ccaps, nil)
+	b.kinds = append(b.kinds, 0)
+	b.out = make([]byte, min(n*(batchItemSize+batchValueSize*len(caps)), maxBatchBufferSize))
+
+	results := make([]BatchResult, n)
+	errs := make([]error, n)
+	usage := e.usage.Load()
+	for done := 0; done < n; {
+		b.used = 0
+		k := call(e, b, done, n-done)
+
+		// the values of the k items are substrings of a single copy of the results
+		out := string(b.out[:b.used])
+		for i := done; i < done+k; i++ {
+			if b.errs[i] != C.WURFL_OK {
+				errs[i] = cErrorToGoError(C.wurfl_error(b.errs[i]))
+				continue
+			}
+			results[i].DeviceID, out = nextBatchValue(out)
+			results[i].Capabilities = make(map[string]string, len(caps))
+			for _, cap := range caps {
+				results[i].Capabilities[cap], out = nextBatchValue(out)
+				if usage != nil {
+					if e.virtualCaps[cap] {
+						usage.recordVirtual(cap)
+					} else {
+						usage.recordStatic(cap, e.mandatoryCaps[cap])
+					}
+				}
+			}
+		}
+		done += k
+		if done < n {
+			b.out = make([]byte, 2*len(b.out))
+		}
+	}
+	return results, errs
+}
+
+// nextBatchValue splits the NUL terminated value at the start of out from the rest
+func nextBatchValue(out string) (string, string) {
+	i := strings.IndexByte(out, 0)
+	return out[:i], out[i+1:]
+}
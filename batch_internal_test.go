@@ -0,0 +1,29 @@
+package wurfl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupBatch_GrowBuffer(t *testing.T) {
+	wengine, err := CreateWithOptions(testWurflZip())
+	require.NoError(t, err)
+	defer wengine.Destroy()
+
+	uas := []string{
+		"ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0",
+		"Mozilla/5.0 (Linux; Android 13; Pixel 7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/116.0.0.0 Mobile Safari/537.36",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/116.0.0.0 Safari/537.36",
+	}
+	caps := []string{"brand_name", "form_factor"}
+	want, werrs := wengine.LookupUserAgents(uas, caps)
+
+	// the shim runs out of room at every item: the buffer grows until they all fit
+	defer func(item, value int) { batchItemSize, batchValueSize = item, value }(batchItemSize, batchValueSize)
+	batchItemSize, batchValueSize = 1, 0
+	got, errs := wengine.LookupUserAgents(uas, caps)
+	assert.Equal(t, werrs, errs)
+	assert.Equal(t, want, got)
+}
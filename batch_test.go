@@ -0,0 +1,115 @@
+package wurfl_test
+
+import (
+	"testing"
+
+	wurfl "github.com/WURFL/golang-wurfl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var batchCaps = []string{"brand_name", "model_name", "form_factor", "complete_device_name"}
+
+// wantBatchResult returns the result of a batch lookup of caps for device
+func wantBatchResult(t *testing.T, device *wurfl.Device, caps []string) wurfl.BatchResult {
+	defer device.Destroy()
+
+	id, err := device.GetDeviceID()
+	require.NoError(t, err)
+	want := wurfl.BatchResult{DeviceID: id, Capabilities: map[string]string{}}
+	for _, cap := range caps {
+		value, err := device.GetStaticCap(cap)
+		if err != nil {
+			value, err = device.GetVirtualCap(cap)
+		}
+		require.NoError(t, err, cap)
+		want.Capabilities[cap] = value
+	}
+	return want
+}
+
+func TestWurfl_LookupUserAgents(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+	defer wengine.Destroy()
+
+	uas := []string{
+		"ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0",
+		"Mozilla/5.0 (Linux; Android 13; Pixel 7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/116.0.0.0 Mobile Safari/537.36",
+		"",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/116.0.0.0 Safari/537.36",
+		"ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0",
+	}
+	results, errs := wengine.LookupUserAgents(uas, batchCaps)
+	require.Len(t, results, len(uas))
+	require.Len(t, errs, len(uas))
+	for i, ua := range uas {
+		device, err := wengine.LookupUserAgent(ua)
+		require.NoError(t, err)
+		assert.NoError(t, errs[i], ua)
+		assert.Equal(t, wantBatchResult(t, device, batchCaps), results[i], ua)
+	}
+
+	// without capabilities, only the device ids
+	results, errs = wengine.LookupUserAgents(uas[:1], nil)
+	assert.Equal(t, []error{nil}, errs)
+	assert.Equal(t, "apple_iphone_ver8_3_subuacfnetwork", results[0].DeviceID)
+	assert.Empty(t, results[0].Capabilities)
+
+	results, errs = wengine.LookupUserAgents(nil, batchCaps)
+	assert.Empty(t, results)
+	assert.Empty(t, errs)
+}
+
+func TestWurfl_LookupHeaderMaps(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+	defer wengine.Destroy()
+
+	IHMaps := []map[string]string{
+		{"User-Agent": "ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0"},
+		{},
+		{
+			"user-agent":         "Mozilla/5.0 (Linux; Android 13; Pixel 7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/116.0.0.0 Mobile Safari/537.36",
+			"sec-ch-ua-platform": `"Android"`,
+			"Sec-CH-UA-Model":    `"Pixel 7"`,
+			"X-Not-Important":    "ignored",
+		},
+	}
+	results, errs := wengine.LookupHeaderMaps(IHMaps, batchCaps)
+	require.Len(t, results, len(IHMaps))
+	require.Len(t, errs, len(IHMaps))
+	for i, IHMap := range IHMaps {
+		device, err := wengine.LookupWithImportantHeaderMap(IHMap)
+		require.NoError(t, err)
+		assert.NoError(t, errs[i], i)
+		assert.Equal(t, wantBatchResult(t, device, batchCaps), results[i], i)
+	}
+}
+
+func TestWurfl_LookupBatch_UnknownCapability(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+	defer wengine.Destroy()
+
+	uas := []string{"ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0", ""}
+	results, errs := wengine.LookupUserAgents(uas, []string{"brand_name", "no_such_capability"})
+	require.Len(t, results, len(uas))
+	for i := range uas {
+		assert.ErrorIs(t, errs[i], wurfl.ErrCapabilityNotFound)
+		assert.ErrorContains(t, errs[i], "no_such_capability")
+		assert.Empty(t, results[i].DeviceID)
+	}
+
+	_, errs = wengine.LookupHeaderMaps([]map[string]string{{"User-Agent": uas[0]}}, []string{"no_such_capability"})
+	assert.ErrorIs(t, errs[0], wurfl.ErrCapabilityNotFound)
+}
+
+func TestWurfl_LookupBatch_CapabilityUsage(t *testing.T) {
+	usage := wurfl.NewCapabilityUsageRecorder()
+	wengine, err := wurfl.CreateWithOptions(fixtureWurflZip(), wurfl.WithCapabilityUsageRecorder(usage))
+	require.NoError(t, err)
+	defer wengine.Destroy()
+
+	_, errs := wengine.LookupUserAgents([]string{"a", "b", "c"}, []string{"brand_name", "form_factor"})
+	assert.Equal(t, []error{nil, nil, nil}, errs)
+	assert.Equal(t, int64(3), usage.StaticCounts()["brand_name"])
+	assert.Equal(t, int64(3), usage.VirtualCounts()["form_factor"])
+}
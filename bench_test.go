@@ -497,6 +497,50 @@ func Benchmark_LookupUserAgent_NoCache(b *testing.B) {
 	b.StopTimer()
 }
 
+// batchBenchUserAgents are the user agents of the batch benchmarks
+var batchBenchUserAgents = []string{
+	"Mozilla/5.0 (Linux; Android 11; SM-M315F) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/90.0.4430.91 Mobile Safari/537.36",
+	"Mozilla/5.0 (iPhone; CPU iPhone OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/116.0.0.0 Safari/537.36",
+	"ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0",
+}
+
+// Benchmark_LookupUserAgent_Loop looks up batchBenchUserAgents one by one, reading the device id and
+// two capabilities of each, to compare with Benchmark_LookupUserAgents
+func Benchmark_LookupUserAgent_Loop(b *testing.B) {
+	wengine := fixtureCreateEngine(nil)
+	defer wengine.Destroy()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, ua := range batchBenchUserAgents {
+			device, _ := wengine.LookupUserAgent(ua)
+			device.GetDeviceID()
+			device.GetStaticCap("brand_name")
+			device.GetVirtualCap("form_factor")
+			device.Destroy()
+		}
+	}
+
+	b.StopTimer()
+}
+
+// Benchmark_LookupUserAgents looks up batchBenchUserAgents in one batch
+func Benchmark_LookupUserAgents(b *testing.B) {
+	wengine := fixtureCreateEngine(nil)
+	defer wengine.Destroy()
+	caps := []string{"brand_name", "form_factor"}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		wengine.LookupUserAgents(batchBenchUserAgents, caps)
+	}
+
+	b.StopTimer()
+}
+
 // func Benchmark_LookupRequest_Cache
 func Benchmark_LookupRequest_Cache(b *testing.B) {
 	wengine := fixtureCreateEngine(nil)
@@ -570,6 +614,9 @@ func Benchmark_LookupWithImportantHeaderMap_Cache(b *testing.B) {
 }
 
 // Benchmark_LookupWithImportantHeaderMap_AllSecChUa_Cache
+//
+// ReportAllocs only counts Go allocations: the C copies of the header values (see arena.go)
+// do not show in allocs/op, see Benchmark_HeaderValueStrategies.
 func Benchmark_LookupWithImportantHeaderMap_AllSecChUa_Cache(b *testing.B) {
 	wengine := fixtureCreateEngine(nil)
 	defer wengine.Destroy()
@@ -588,6 +635,7 @@ func Benchmark_LookupWithImportantHeaderMap_AllSecChUa_Cache(b *testing.B) {
 	IHMap["Sec-CH-UA-Arch"] = "x86"
 	IHMap["Sec-Ch-Ua-Full-Version-List"] = "\"Chromium\";v=\"146.0.7680.157\", \"Not-A.Brand\";v=\"24.0.0.0\", \"Android WebView\";v=\"146.0.7680.157\""
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 
@@ -598,6 +646,80 @@ func Benchmark_LookupWithImportantHeaderMap_AllSecChUa_Cache(b *testing.B) {
 	b.StopTimer()
 }
 
+// allSecChUaHeaders are the headers of Benchmark_LookupWithImportantHeaderMap_AllSecChUa_Cache
+var allSecChUaHeaders = http.Header{
+	"Accept-Encoding":             {"gzip, br"},
+	"X-Requested-With":            {"com.instagram.android"},
+	"User-Agent":                  {"Mozilla/5.0 (Linux; Android 11; SM-M315F) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/90.0.4430.91 Mobile Safari/537.36"},
+	"Sec-Ch-Ua":                   {"\" Not A;Brand\";v=\"99\", \"Chromium\";v=\"90\", \"Google Chrome\";v=\"90\""},
+	"Sec-Ch-Ua-Full-Version":      {"90.0.4430.91"},
+	"Sec-Ch-Ua-Platform":          {"Android"},
+	"Sec-Ch-Ua-Platform-Version":  {"11"},
+	"Sec-Ch-Ua-Model":             {"SM-M315F"},
+	"Sec-Ch-Ua-Mobile":            {"?1"},
+	"Sec-Ch-Ua-Arch":              {"x86"},
+	"Sec-Ch-Ua-Full-Version-List": {"\"Chromium\";v=\"146.0.7680.157\", \"Not-A.Brand\";v=\"24.0.0.0\", \"Android WebView\";v=\"146.0.7680.157\""},
+}
+
+// Benchmark_LookupRequest_AllSecChUa_Cache
+func Benchmark_LookupRequest_AllSecChUa_Cache(b *testing.B) {
+	wengine := fixtureCreateEngine(nil)
+	defer wengine.Destroy()
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header = allSecChUaHeaders
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		device, _ := wengine.LookupRequest(req)
+		device.Destroy()
+	}
+}
+
+// Benchmark_GetHeaderQuality_AllSecChUa
+func Benchmark_GetHeaderQuality_AllSecChUa(b *testing.B) {
+	wengine := fixtureCreateEngine(nil)
+	defer wengine.Destroy()
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header = allSecChUaHeaders
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = wengine.GetHeaderQuality(req)
+	}
+}
+
+// Benchmark_HeaderValueStrategies compares copying the header values of an all Sec-CH-UA
+// request to C strings with a C.CString/C.free pair each, and to a single pooled arena.
+// allocs/op only counts Go allocations: the C allocations are reported as cmallocs/op.
+func Benchmark_HeaderValueStrategies(b *testing.B) {
+	var values []string
+	for _, v := range allSecChUaHeaders {
+		values = append(values, v[0])
+	}
+
+	strategies := []struct {
+		name string
+		copy func() int
+	}{
+		{"CString", wurfl.BenchmarkableHeaderValuesCString(values)},
+		{"Arena", wurfl.BenchmarkableHeaderValuesArena(values)},
+	}
+	for _, s := range strategies {
+		b.Run(s.name, func(b *testing.B) {
+			b.ReportAllocs()
+			mallocs := 0
+			for i := 0; i < b.N; i++ {
+				mallocs += s.copy()
+			}
+			b.ReportMetric(float64(mallocs)/float64(b.N), "cmallocs/op")
+		})
+	}
+}
+
 // Benchmark_LookupWithImportantHeaderMap_NoCache
 func Benchmark_LookupWithImportantHeaderMap_NoCache(b *testing.B) {
 	wengine := fixtureCreateEngineCachesize(nil, "")
@@ -1012,7 +1134,7 @@ func Benchmark_TrieGet(b *testing.B) {
 // header name lookup using the same set of 15 WURFL important header names.
 //
 // Strategies:
-//   - Trie: | 0x20 byte folding per byte, O(len(key)), 0 allocs
+//   - Trie: table-driven token case folding per byte, O(len(key)), 0 allocs
 //   - Map: strings.ToLower + map access, 1 alloc (unavoidable for hashing)
 //
 // Setup (building tries/maps) is done before b.Run, so it's excluded from timing.
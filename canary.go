@@ -0,0 +1,256 @@
+package wurfl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ErrCorpusMismatch is returned when the detections of an engine do not match a canary Corpus
+var ErrCorpusMismatch = errors.New("detection does not match the canary corpus")
+
+// Corpus is a set of requests with the detection results expected for them, used to check
+// that a data file and its patches still detect the devices that matter before an engine
+// is put in service. It is usually kept in a JSON file, shared between the engine
+// configuration (WithCanaryCorpusFile) and the application tests (Wurfl.Verify):
+//
+//	{
+//		"cases": [
+//			{
+//				"name": "iPhone app",
+//				"user_agent": "ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0",
+//				"device_id": "apple_iphone_ver8_3_subuacfnetwork",
+//				"capabilities": {"brand_name": "Apple"},
+//				"virtual_capabilities": {"form_factor": "Smartphone"}
+//			}
+//		]
+//	}
+type Corpus struct {
+	Cases []CorpusCase `json:"cases"`
+}
+
+// CorpusCase is a request of a Corpus, as a user agent and/or a set of headers, with the
+// expected device id and capability values. Only the expectations that are set are checked.
+type CorpusCase struct {
+	Name                string            `json:"name,omitempty"`
+	UserAgent           string            `json:"user_agent,omitempty"`
+	Headers             map[string]string `json:"headers,omitempty"`
+	DeviceID            string            `json:"device_id,omitempty"`
+	Capabilities        map[string]string `json:"capabilities,omitempty"`
+	VirtualCapabilities map[string]string `json:"virtual_capabilities,omitempty"`
+}
+
+// label names the case in the mismatch reports
+func (cc *CorpusCase) label(i int) string {
+	if cc.Name != "" {
+		return cc.Name
+	}
+	return fmt.Sprintf("case %d", i)
+}
+
+// Validate checks that every case has a request and at least one expectation
+func (c *Corpus) Validate() error {
+	if len(c.Cases) == 0 {
+		return fmt.Errorf("%w: empty corpus", ErrInvalidParameter)
+	}
+	for i := range c.Cases {
+		cc := &c.Cases[i]
+		if cc.UserAgent == "" && len(cc.Headers) == 0 {
+			return fmt.Errorf("%w: %s has no user_agent nor headers", ErrInvalidParameter, cc.label(i))
+		}
+		if cc.DeviceID == "" && len(cc.Capabilities) == 0 && len(cc.VirtualCapabilities) == 0 {
+			return fmt.Errorf("%w: %s has no expected device_id or capabilities", ErrInvalidParameter, cc.label(i))
+		}
+	}
+	return nil
+}
+
+// LoadCorpus reads and validates a JSON Corpus file
+func LoadCorpus(path string) (*Corpus, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fsError(err)
+	}
+	c := &Corpus{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return nil, fmt.Errorf("wurfl: cannot parse corpus file %s: %w", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Mismatch is a detection result that differs from what a CorpusCase expects
+type Mismatch struct {
+	Case     string // case name, or "case N"
+	Field    string // "device_id", or the capability name
+	Expected string
+	Actual   string // the detected value, or the error message
+}
+
+// VerifyError lists every Mismatch found by Verify
+type VerifyError struct {
+	Mismatches []Mismatch
+}
+
+// Error returns all the mismatches in a single message.
+func (e *VerifyError) Error() string {
+	msgs := make([]string, len(e.Mismatches))
+	for i, m := range e.Mismatches {
+		msgs[i] = fmt.Sprintf("%s: %s is %q, expected %q", m.Case, m.Field, m.Actual, m.Expected)
+	}
+	return "wurfl: canary corpus failed: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap returns ErrCorpusMismatch, allowing errors.Is to work.
+func (e *VerifyError) Unwrap() error {
+	return ErrCorpusMismatch
+}
+
+// Verify looks up every case of the corpus and returns a *VerifyError listing all the
+// detections that do not match the expectations, or nil if all of them match.
+func (w *Wurfl) Verify(corpus *Corpus) error {
+	return verifyCorpus(corpus, w.lookupCorpusCase)
+}
+
+// verify runs Verify on an engine that is not installed in a Wurfl yet
+func (e *engine) verify(corpus *Corpus) error {
+	return verifyCorpus(corpus, e.lookupCorpusCase)
+}
+
+// verifyCorpus checks the Device returned by lookup for every case of corpus, see Verify
+func verifyCorpus(corpus *Corpus, lookup func(cc *CorpusCase) (*Device, error)) error {
+	if err := corpus.Validate(); err != nil {
+		return err
+	}
+
+	var mismatches []Mismatch
+	for i := range corpus.Cases {
+		cc := &corpus.Cases[i]
+		label := cc.label(i)
+		mismatch := func(field, expected, actual string) {
+			mismatches = append(mismatches, Mismatch{Case: label, Field: field, Expected: expected, Actual: actual})
+		}
+
+		device, err := lookup(cc)
+		if errors.Is(err, ErrEngineClosed) {
+			return err
+		}
+		if err != nil {
+			mismatch("lookup", "a device", "error: "+err.Error())
+			continue
+		}
+
+		if cc.DeviceID != "" {
+			id, err := device.GetDeviceID()
+			if err != nil {
+				id = "error: " + err.Error()
+			}
+			if id != cc.DeviceID {
+				mismatch("device_id", cc.DeviceID, id)
+			}
+		}
+		for _, name := range sortedKeys(cc.Capabilities) {
+			value, err := device.GetStaticCap(name)
+			if err != nil {
+				value = "error: " + err.Error()
+			}
+			if value != cc.Capabilities[name] {
+				mismatch(name, cc.Capabilities[name], value)
+			}
+		}
+		for _, name := range sortedKeys(cc.VirtualCapabilities) {
+			value, err := device.GetVirtualCap(name)
+			if err != nil {
+				value = "error: " + err.Error()
+			}
+			if value != cc.VirtualCapabilities[name] {
+				mismatch(name, cc.VirtualCapabilities[name], value)
+			}
+		}
+		device.Destroy()
+	}
+
+	if len(mismatches) != 0 {
+		return &VerifyError{Mismatches: mismatches}
+	}
+	return nil
+}
+
+// lookupCorpusCase looks up cc, see headers
+func (w *Wurfl) lookupCorpusCase(cc *CorpusCase) (*Device, error) {
+	if headers := cc.headers(); headers != nil {
+		return w.LookupWithImportantHeaderMap(headers)
+	}
+	return w.LookupUserAgent(cc.UserAgent)
+}
+
+// lookupCorpusCase is Wurfl.lookupCorpusCase on e, see engine.verify
+func (e *engine) lookupCorpusCase(cc *CorpusCase) (*Device, error) {
+	if headers := cc.headers(); headers != nil {
+		return e.lookupWithImportantHeaderMap(headers)
+	}
+	return e.lookupUserAgent(cc.UserAgent)
+}
+
+// headers returns the headers of cc with its user agent added, or nil if cc has no headers
+// and is looked up by user agent
+func (cc *CorpusCase) headers() map[string]string {
+	if len(cc.Headers) == 0 {
+		return nil
+	}
+	if cc.UserAgent == "" {
+		return cc.Headers
+	}
+	headers := make(map[string]string, len(cc.Headers)+1)
+	for k, v := range cc.Headers {
+		headers[k] = v
+	}
+	headers["User-Agent"] = cc.UserAgent
+	return headers
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WithCanaryCorpus verifies the engine against corpus right after loading the data file,
+// failing the creation (or the Reload) with a *VerifyError if a detection does not match.
+func WithCanaryCorpus(corpus *Corpus) Option {
+	return func(o *options) error {
+		if corpus == nil {
+			return &OptionError{Option: "WithCanaryCorpus", Err: ErrInvalidParameter}
+		}
+		if err := corpus.Validate(); err != nil {
+			return &OptionError{Option: "WithCanaryCorpus", Err: err}
+		}
+		o.corpus = corpus
+		o.corpusPath = ""
+		return nil
+	}
+}
+
+// WithCanaryCorpusFile is like WithCanaryCorpus, reading the corpus from a JSON file
+func WithCanaryCorpusFile(path string) Option {
+	return func(o *options) error {
+		corpus, err := LoadCorpus(path)
+		if err != nil {
+			return &OptionError{Option: "WithCanaryCorpusFile", Err: err}
+		}
+		o.corpus = corpus
+		o.corpusPath = path
+		return nil
+	}
+}
@@ -0,0 +1,98 @@
+package wurfl_test
+
+import (
+	"errors"
+	"testing"
+
+	wurfl "github.com/WURFL/golang-wurfl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testCorpus = `{
+	"cases": [
+		{
+			"name": "iPhone app",
+			"user_agent": "ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0",
+			"device_id": "apple_iphone_ver8_3_subuacfnetwork",
+			"capabilities": {"is_ott": "false"},
+			"virtual_capabilities": {"is_android": "false"}
+		},
+		{
+			"name": "iPhone app, headers",
+			"headers": {"User-Agent": "ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0"},
+			"device_id": "apple_iphone_ver8_3_subuacfnetwork"
+		}
+	]
+}`
+
+func TestLoadCorpus(t *testing.T) {
+	corpus, err := wurfl.LoadCorpus(writeTestFile(t, "corpus.json", []byte(testCorpus)))
+	require.NoError(t, err)
+	require.Len(t, corpus.Cases, 2)
+	assert.Equal(t, "iPhone app", corpus.Cases[0].Name)
+	assert.Equal(t, map[string]string{"is_ott": "false"}, corpus.Cases[0].Capabilities)
+
+	_, err = wurfl.LoadCorpus(writeTestFile(t, "typo.json", []byte(`{"cases": [{"useragent": "x"}]}`)))
+	assert.Error(t, err)
+
+	_, err = wurfl.LoadCorpus(writeTestFile(t, "empty.json", []byte(`{"cases": []}`)))
+	assert.ErrorIs(t, err, wurfl.ErrInvalidParameter)
+
+	// a case without expectations checks nothing
+	_, err = wurfl.LoadCorpus(writeTestFile(t, "noexp.json", []byte(`{"cases": [{"user_agent": "x"}]}`)))
+	assert.ErrorIs(t, err, wurfl.ErrInvalidParameter)
+}
+
+func TestWurfl_Verify(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+	defer wengine.Destroy()
+
+	corpus, err := wurfl.LoadCorpus(writeTestFile(t, "corpus.json", []byte(testCorpus)))
+	require.NoError(t, err)
+	assert.NoError(t, wengine.Verify(corpus))
+
+	bad := &wurfl.Corpus{Cases: []wurfl.CorpusCase{{
+		UserAgent:           "ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0",
+		DeviceID:            "generic",
+		Capabilities:        map[string]string{"is_ott": "true", "not_a_capability": "x"},
+		VirtualCapabilities: map[string]string{"is_android": "false"},
+	}}}
+	err = wengine.Verify(bad)
+	assert.ErrorIs(t, err, wurfl.ErrCorpusMismatch)
+	var verifyErr *wurfl.VerifyError
+	require.True(t, errors.As(err, &verifyErr))
+	require.Len(t, verifyErr.Mismatches, 3)
+	assert.Equal(t, wurfl.Mismatch{Case: "case 0", Field: "device_id",
+		Expected: "generic", Actual: "apple_iphone_ver8_3_subuacfnetwork"}, verifyErr.Mismatches[0])
+	assert.Equal(t, "is_ott", verifyErr.Mismatches[1].Field)
+	assert.Equal(t, "false", verifyErr.Mismatches[1].Actual)
+	assert.Equal(t, "not_a_capability", verifyErr.Mismatches[2].Field)
+	assert.Contains(t, verifyErr.Mismatches[2].Actual, "error")
+	assert.Contains(t, err.Error(), "case 0: device_id")
+}
+
+func TestCreateWithOptions_CanaryCorpus(t *testing.T) {
+	path := writeTestFile(t, "corpus.json", []byte(testCorpus))
+
+	wengine, err := wurfl.CreateWithOptions(fixtureWurflZip(), wurfl.WithCanaryCorpusFile(path))
+	require.NoError(t, err)
+	defer wengine.Destroy()
+
+	config, err := wengine.Config()
+	require.NoError(t, err)
+	assert.Equal(t, path, config.CanaryCorpus)
+
+	bad := &wurfl.Corpus{Cases: []wurfl.CorpusCase{{
+		UserAgent: "ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0",
+		DeviceID:  "generic",
+	}}}
+	_, err = wurfl.CreateWithOptions(fixtureWurflZip(), wurfl.WithCanaryCorpus(bad))
+	assert.ErrorIs(t, err, wurfl.ErrCorpusMismatch)
+
+	// Reload verifies the new data against the same corpus
+	assert.NoError(t, wengine.Reload(fixtureWurflZip(), nil))
+
+	_, err = wurfl.CreateWithOptions(fixtureWurflZip(), wurfl.WithCanaryCorpus(nil))
+	assert.ErrorIs(t, err, wurfl.ErrInvalidParameter)
+}
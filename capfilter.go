@@ -0,0 +1,170 @@
+package wurfl
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// ErrRedundantCapability is reported for a capability filter entry that has no effect:
+// a duplicate, or a mandatory capability that is always loaded.
+var ErrRedundantCapability = errors.New("redundant capability in capability filter")
+
+// CapFilterIssue is a single problem found in a capability filter
+type CapFilterIssue struct {
+	Capability string
+	Err        error  // ErrCantLoadCapabilityNotFound or ErrRedundantCapability
+	Detail     string // human readable description of the problem
+	Suggestion string // for unknown capabilities, the closest name in the data file if any
+}
+
+// Error returns the capability and the description of the issue, with the suggestion if any.
+func (i *CapFilterIssue) Error() string {
+	msg := i.Capability + ": " + i.Detail
+	if i.Suggestion != "" {
+		msg += ", did you mean " + i.Suggestion + "?"
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error, allowing errors.Is to work.
+func (i *CapFilterIssue) Unwrap() error {
+	return i.Err
+}
+
+// CapFilterError lists every issue found in a capability filter
+type CapFilterError struct {
+	Issues []*CapFilterIssue
+}
+
+// Error returns all the issues in a single message.
+func (e *CapFilterError) Error() string {
+	msgs := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		msgs[i] = issue.Error()
+	}
+	return "wurfl: invalid capability filter: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the issues, allowing errors.Is and errors.As to work on each of them.
+func (e *CapFilterError) Unwrap() []error {
+	errs := make([]error, len(e.Issues))
+	for i, issue := range e.Issues {
+		errs[i] = issue
+	}
+	return errs
+}
+
+// GetMandatoryCaps returns the capabilities libwurfl always loads, even when a capability
+// filter is used, because the virtual capabilities depend on them.
+func (w *Wurfl) GetMandatoryCaps() []string {
+	e := w.acquire()
+	if e == nil {
+		return nil
+	}
+	defer e.release()
+
+	return e.enumNames(WurflEnumMandatoryCapabilities)
+}
+
+// ValidateCapFilter checks a capability filter against the capabilities of the WURFL data file
+// at path, before using it in Create. It returns nil if the filter is valid, or a
+// *CapFilterError listing all at once:
+//   - unknown or misspelled capabilities (ErrCantLoadCapabilityNotFound), with a suggestion
+//     when a capability with a close name exists
+//   - virtual capabilities, that cannot be filtered (ErrCantLoadCapabilityNotFound too)
+//   - duplicated and mandatory capabilities, that have no effect (ErrRedundantCapability)
+//
+// The data file is fully loaded, so this takes as long as creating an engine.
+func ValidateCapFilter(path string, caps []string) error {
+	e, err := newEngine(path, defaultOptions())
+	if err != nil {
+		return err
+	}
+	defer e.unref()
+
+	return checkCapFilter(caps,
+		e.enumNames(WurflEnumStaticCapabilities),
+		e.enumNames(WurflEnumVirtualCapabilities),
+		e.enumNames(WurflEnumMandatoryCapabilities))
+}
+
+// checkCapFilter checks caps against the names of the static, virtual and mandatory capabilities
+func checkCapFilter(caps, static, virtual, mandatory []string) error {
+	staticSet := stringSet(static)
+	virtualSet := stringSet(virtual)
+	mandatorySet := stringSet(mandatory)
+
+	var issues []*CapFilterIssue
+	seen := make(map[string]bool, len(caps))
+	for _, c := range caps {
+		switch {
+		case seen[c]:
+			issues = append(issues, &CapFilterIssue{Capability: c, Err: ErrRedundantCapability,
+				Detail: "listed more than once"})
+		case staticSet[c] && mandatorySet[c]:
+			issues = append(issues, &CapFilterIssue{Capability: c, Err: ErrRedundantCapability,
+				Detail: "mandatory capability, always loaded"})
+		case staticSet[c]:
+		case virtualSet[c]:
+			issues = append(issues, &CapFilterIssue{Capability: c, Err: ErrCantLoadCapabilityNotFound,
+				Detail: "virtual capability, filter the static capabilities it depends on instead"})
+		default:
+			issues = append(issues, &CapFilterIssue{Capability: c, Err: ErrCantLoadCapabilityNotFound,
+				Detail: "unknown capability", Suggestion: closestName(c, static)})
+		}
+		seen[c] = true
+	}
+
+	if len(issues) != 0 {
+		return &CapFilterError{Issues: issues}
+	}
+	return nil
+}
+
+func stringSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, n := range names {
+		set[n] = true
+	}
+	return set
+}
+
+// closestName returns the name in names closest to s, or "" if none is close enough to
+// be a likely typo: at most one edit every four characters, and at least one.
+func closestName(s string, names []string) string {
+	lower := strings.ToLower(s)
+	maxDistance := len(s)/4 + 1
+
+	best, bestDistance := "", maxDistance+1
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted) // deterministic choice between names at the same distance
+	for _, n := range sorted {
+		d := editDistance(lower, n)
+		if d < bestDistance {
+			best, bestDistance = n, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
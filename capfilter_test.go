@@ -0,0 +1,63 @@
+package wurfl_test
+
+import (
+	"errors"
+	"testing"
+
+	wurfl "github.com/WURFL/golang-wurfl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWurfl_GetMandatoryCaps(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+	require.NotNil(t, wengine)
+	defer wengine.Destroy()
+
+	mandatory := wengine.GetMandatoryCaps()
+	assert.NotEmpty(t, mandatory)
+	assert.Subset(t, wengine.GetAllCaps(), mandatory)
+}
+
+func TestValidateCapFilter(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+	require.NotNil(t, wengine)
+	mandatory := wengine.GetMandatoryCaps()
+	require.NotEmpty(t, mandatory)
+	wengine.Destroy()
+
+	assert.NoError(t, wurfl.ValidateCapFilter(fixtureWurflZip(), []string{"model_name"}))
+
+	err := wurfl.ValidateCapFilter(fixtureWurflZip(), []string{
+		"modle_name",
+		"model_name",
+		"form_factor",
+		"model_name",
+		mandatory[0],
+		"golang_wurfl_nonexistent_capability",
+	})
+	var cfErr *wurfl.CapFilterError
+	require.True(t, errors.As(err, &cfErr))
+	assert.ErrorIs(t, err, wurfl.ErrCantLoadCapabilityNotFound)
+	assert.ErrorIs(t, err, wurfl.ErrRedundantCapability)
+	require.Len(t, cfErr.Issues, 5)
+
+	assert.Equal(t, "modle_name", cfErr.Issues[0].Capability)
+	assert.Equal(t, "model_name", cfErr.Issues[0].Suggestion)
+	assert.Contains(t, cfErr.Issues[0].Error(), "did you mean model_name?")
+	assert.Equal(t, "form_factor", cfErr.Issues[1].Capability)
+	assert.ErrorIs(t, cfErr.Issues[1], wurfl.ErrCantLoadCapabilityNotFound)
+	assert.Equal(t, "model_name", cfErr.Issues[2].Capability)
+	assert.ErrorIs(t, cfErr.Issues[2], wurfl.ErrRedundantCapability)
+	assert.Equal(t, mandatory[0], cfErr.Issues[3].Capability)
+	assert.ErrorIs(t, cfErr.Issues[3], wurfl.ErrRedundantCapability)
+	assert.Empty(t, cfErr.Issues[4].Suggestion)
+
+	err = wurfl.ValidateCapFilter("/nodir/wurfl.zip", []string{"model_name"})
+	assert.ErrorIs(t, err, wurfl.ErrFileNotFound)
+}
+
+func TestCreate_CapFilterError(t *testing.T) {
+	_, err := wurfl.CreateWithOptions(fixtureWurflZip(), wurfl.WithCapabilityFilter("brand_nmae", "model_name"))
+	assert.ErrorIs(t, err, wurfl.ErrCantLoadCapabilityNotFound)
+}
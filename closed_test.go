@@ -0,0 +1,236 @@
+package wurfl_test
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+
+	wurfl "github.com/WURFL/golang-wurfl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWurfl_ClosedEngine(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+	wengine.Destroy()
+
+	ua := "ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0"
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	req.Header.Set("User-Agent", ua)
+	headers := map[string]string{"User-Agent": ua}
+
+	lookups := map[string]func() (*wurfl.Device, error){
+		"LookupDeviceID":                       func() (*wurfl.Device, error) { return wengine.LookupDeviceID("generic") },
+		"LookupUserAgent":                      func() (*wurfl.Device, error) { return wengine.LookupUserAgent(ua) },
+		"LookupRequest":                        func() (*wurfl.Device, error) { return wengine.LookupRequest(req) },
+		"LookupDeviceIDWithRequest":            func() (*wurfl.Device, error) { return wengine.LookupDeviceIDWithRequest("generic", req) },
+		"LookupWithImportantHeaderMap":         func() (*wurfl.Device, error) { return wengine.LookupWithImportantHeaderMap(headers) },
+		"LookupDeviceIDWithImportantHeaderMap": func() (*wurfl.Device, error) { return wengine.LookupDeviceIDWithImportantHeaderMap("generic", headers) },
+		"LookupHeader":                         func() (*wurfl.Device, error) { return wengine.LookupHeader(req.Header) },
+		"LookupDeviceIDWithHeader":             func() (*wurfl.Device, error) { return wengine.LookupDeviceIDWithHeader("generic", req.Header) },
+		"LookupHeaderSource":                   func() (*wurfl.Device, error) { return wengine.LookupHeaderSource(&peekHeaders{}) },
+		"LookupDeviceIDWithHeaderSource": func() (*wurfl.Device, error) {
+			return wengine.LookupDeviceIDWithHeaderSource("generic", &peekHeaders{})
+		},
+	}
+	for name, lookup := range lookups {
+		device, err := lookup()
+		assert.Nil(t, device, name)
+		assert.ErrorIs(t, err, wurfl.ErrEngineClosed, name)
+		// code written against previous versions checks ErrInvalidHandle
+		assert.ErrorIs(t, err, wurfl.ErrInvalidHandle, name)
+	}
+
+	calls := map[string]func() error{
+		"SetAttr":                  func() error { return wengine.SetAttr(wurfl.WurflAttrExtraHeadersExperimental, 0) },
+		"SetLogPath":               func() error { return wengine.SetLogPath("/tmp/wurfl.log") },
+		"SetUpdaterDataURL":        func() error { return wengine.SetUpdaterDataURL("https://data.scientiamobile.com/xxxxx/wurfl.zip") },
+		"SetUpdaterDataFrequency":  func() error { return wengine.SetUpdaterDataFrequency(wurfl.WurflUpdaterFrequencyDaily) },
+		"SetUpdaterDataURLTimeout": func() error { return wengine.SetUpdaterDataURLTimeout(1000, 1000) },
+		"SetUpdaterLogPath":        func() error { return wengine.SetUpdaterLogPath("/tmp/updater.log") },
+		"UpdaterRunonce":           wengine.UpdaterRunonce,
+		"UpdaterStart":             wengine.UpdaterStart,
+		"UpdaterStop":              wengine.UpdaterStop,
+		"Reload":                   func() error { return wengine.Reload(fixtureWurflZip(), nil) },
+		"SetCapabilityUsageRecorder": func() error {
+			return wengine.SetCapabilityUsageRecorder(wurfl.NewCapabilityUsageRecorder())
+		},
+		"GetAttr": func() error {
+			_, err := wengine.GetAttr(wurfl.WurflAttrExtraHeadersExperimental)
+			return err
+		},
+		"Attrs": func() error {
+			_, err := wengine.Attrs()
+			return err
+		},
+		"Config": func() error {
+			_, err := wengine.Config()
+			return err
+		},
+		"GetHeaderQuality": func() error {
+			_, err := wengine.GetHeaderQuality(req)
+			return err
+		},
+		"GetHeaderQualityWithHeader": func() error {
+			_, err := wengine.GetHeaderQualityWithHeader(req.Header)
+			return err
+		},
+		"GetHeaderQualityWithHeaderSource": func() error {
+			_, err := wengine.GetHeaderQualityWithHeaderSource(&peekHeaders{})
+			return err
+		},
+		"LookupUserAgents": func() error {
+			_, errs := wengine.LookupUserAgents([]string{ua}, []string{"brand_name"})
+			return errs[0]
+		},
+		"LookupHeaderMaps": func() error {
+			_, errs := wengine.LookupHeaderMaps([]map[string]string{headers}, []string{"brand_name"})
+			return errs[0]
+		},
+		"DetectUserAgent": func() error {
+			_, err := wengine.DetectUserAgent(ua)
+			return err
+		},
+		"DetectRequest": func() error {
+			_, err := wengine.DetectRequest(req)
+			return err
+		},
+		"Verify": func() error {
+			return wengine.Verify(&wurfl.Corpus{Cases: []wurfl.CorpusCase{{UserAgent: ua, DeviceID: "generic"}}})
+		},
+	}
+	for name, call := range calls {
+		assert.ErrorIs(t, call(), wurfl.ErrEngineClosed, name)
+	}
+
+	assert.Empty(t, wengine.GetAllCaps())
+	assert.Empty(t, wengine.GetAllVCaps())
+	assert.Empty(t, wengine.GetAllDeviceIds())
+	assert.Empty(t, wengine.GetMandatoryCaps())
+	assert.Empty(t, wengine.GetInfo())
+	assert.Empty(t, wengine.GetLastLoadTime())
+	assert.False(t, wengine.HasCapability("brand_name"))
+	assert.False(t, wengine.HasVirtualCapability("is_android"))
+	assert.False(t, wengine.IsUserAgentFrozen(ua))
+	assert.False(t, wengine.IsUserAgentFrozenWithHeader(req.Header))
+
+	wengine.Destroy()
+}
+
+func TestDevice_ClosedEngine(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+
+	device, err := wengine.LookupUserAgent("ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	require.NoError(t, err)
+
+	wengine.Destroy()
+
+	calls := map[string]func() error{
+		"GetDeviceID": func() error {
+			_, err := device.GetDeviceID()
+			return err
+		},
+		"GetUserAgent": func() error {
+			_, err := device.GetUserAgent()
+			return err
+		},
+		"GetOriginalUserAgent": func() error {
+			_, err := device.GetOriginalUserAgent()
+			return err
+		},
+		"GetNormalizedUserAgent": func() error {
+			_, err := device.GetNormalizedUserAgent()
+			return err
+		},
+		"GetStaticCap": func() error {
+			_, err := device.GetStaticCap("brand_name")
+			return err
+		},
+		"GetStaticCaps": func() error {
+			_, err := device.GetStaticCaps([]string{"brand_name"})
+			return err
+		},
+		"Detection": func() error {
+			_, err := device.Detection()
+			return err
+		},
+		"GetCapabilityAsInt": func() error {
+			_, err := device.GetCapabilityAsInt("resolution_width")
+			return err
+		},
+		"GetVirtualCap": func() error {
+			_, err := device.GetVirtualCap("is_android")
+			return err
+		},
+		"GetVirtualCaps": func() error {
+			_, err := device.GetVirtualCaps([]string{"is_android"})
+			return err
+		},
+		"GetVirtualCapabilityAsInt": func() error {
+			_, err := device.GetVirtualCapabilityAsInt("advertised_browser_version")
+			return err
+		},
+		"ORTB2GetDevicetype": func() error {
+			_, err := device.ORTB2GetDevicetype()
+			return err
+		},
+	}
+	for name, call := range calls {
+		assert.ErrorIs(t, call(), wurfl.ErrEngineClosed, name)
+	}
+
+	assert.Empty(t, device.GetRootID())
+	assert.Empty(t, device.GetParentID())
+	assert.False(t, device.IsRoot())
+	assert.Empty(t, device.GetCapability("brand_name"))
+	assert.Empty(t, device.GetCapabilities([]string{"brand_name"}))
+	assert.Empty(t, device.GetVirtualCapability("is_android"))
+	assert.Empty(t, device.GetVirtualCapabilities([]string{"is_android"}))
+	assert.Equal(t, wurfl.WurflMatchTypeNone, device.GetMatchType())
+
+	device.Destroy()
+	device.Destroy()
+
+	// a destroyed device is an invalid handle, not a closed engine
+	_, err = device.GetDeviceID()
+	assert.ErrorIs(t, err, wurfl.ErrInvalidHandle)
+	assert.NotErrorIs(t, err, wurfl.ErrEngineClosed)
+}
+
+// TestWurfl_DestroyConcurrent destroys the engine while other goroutines look up devices
+// and read their capabilities: every call must either succeed or fail with ErrEngineClosed.
+// Run it with -race.
+func TestWurfl_DestroyConcurrent(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for j := 0; j < 200; j++ {
+				device, err := wengine.LookupUserAgent("ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+				if err != nil {
+					if !errors.Is(err, wurfl.ErrEngineClosed) {
+						t.Errorf("LookupUserAgent: unexpected error %v", err)
+					}
+					continue
+				}
+				if _, err := device.GetStaticCap("brand_name"); err != nil && !errors.Is(err, wurfl.ErrEngineClosed) {
+					t.Errorf("GetStaticCap: unexpected error %v", err)
+				}
+				if _, err := device.GetVirtualCap("is_android"); err != nil && !errors.Is(err, wurfl.ErrEngineClosed) {
+					t.Errorf("GetVirtualCap: unexpected error %v", err)
+				}
+				device.Destroy()
+			}
+		}()
+	}
+
+	close(start)
+	wengine.Destroy()
+	wg.Wait()
+}
@@ -0,0 +1,496 @@
+package wurfl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is a declarative description of a WURFL engine. It can be loaded from a JSON
+// file with LoadConfig, overridden by WURFL_* environment variables and turned into a
+// running engine with CreateFromConfig.
+//
+//	{
+//		"data_file": "/usr/share/wurfl/wurfl.zip",
+//		"cache_size": 100000,
+//		"capability_fallback_cache": "limited",
+//		"updater": {
+//			"data_url": "https://data.scientiamobile.com/xxxxx/wurfl.zip",
+//			"frequency": "daily",
+//			"start": true
+//		}
+//	}
+type Config struct {
+	DataFile                string            `json:"data_file"`
+	Patches                 []string          `json:"patches,omitempty"`
+	CapabilityFilter        []string          `json:"capability_filter,omitempty"`
+	CacheProvider           string            `json:"cache_provider,omitempty"` // "lru", "none" or empty for the libwurfl default
+	CacheSize               int               `json:"cache_size,omitempty"`
+	LogPath                 string            `json:"log_path,omitempty"`
+	CapabilityFallbackCache string            `json:"capability_fallback_cache,omitempty"` // "default", "disabled" or "limited"
+	ValidateDataFile        bool              `json:"validate_data_file,omitempty"`
+	CanaryCorpus            string            `json:"canary_corpus,omitempty"` // path of a JSON Corpus file
+	FallbackDeviceID        string            `json:"fallback_device_id,omitempty"`
+	DetectionCaps           []string          `json:"detection_caps,omitempty"`
+	LookupLimit             LookupLimitConfig `json:"lookup_limit"`
+	Updater                 UpdaterConfig     `json:"updater"`
+}
+
+// LookupLimitConfig holds the lookup limiter settings of a Config, see WithMaxConcurrentLookups
+type LookupLimitConfig struct {
+	MaxConcurrentLookups int  `json:"max_concurrent_lookups,omitempty"` // 0 when unlimited
+	QueueSize            *int `json:"queue_size,omitempty"`             // unbounded when missing or negative
+	QueueTimeout         int  `json:"queue_timeout_ms,omitempty"`       // 0 for no timeout
+}
+
+// UpdaterConfig holds the updater settings of a Config
+type UpdaterConfig struct {
+	DataURL             string `json:"data_url,omitempty"`
+	Frequency           string `json:"frequency,omitempty"` // "daily" or "weekly"
+	ConnectionTimeout   *int   `json:"connection_timeout_ms,omitempty"`
+	DataTransferTimeout *int   `json:"data_transfer_timeout_ms,omitempty"`
+	LogPath             string `json:"log_path,omitempty"`
+	UserAgent           string `json:"user_agent,omitempty"`
+	Start               bool   `json:"start,omitempty"`
+}
+
+// FieldError reports an invalid Config field
+type FieldError struct {
+	Field string // json name of the field, ie: "updater.frequency"
+	Err   error
+}
+
+// Error returns the field name followed by the underlying error message.
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error, allowing errors.Is to work.
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigError lists every invalid field of a Config
+type ConfigError struct {
+	Fields []*FieldError
+}
+
+// Error returns all the field errors in a single message.
+func (e *ConfigError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Error()
+	}
+	return "wurfl: invalid configuration: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the field errors, allowing errors.Is and errors.As to work on each of them.
+func (e *ConfigError) Unwrap() []error {
+	errs := make([]error, len(e.Fields))
+	for i, f := range e.Fields {
+		errs[i] = f
+	}
+	return errs
+}
+
+// LoadConfig reads a JSON configuration file and applies the WURFL_* environment variable
+// overrides on top of it. If path is empty the configuration comes from the environment only.
+// Unknown JSON fields are reported as errors, to catch typos.
+func LoadConfig(path string) (*Config, error) {
+	c := &Config{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fsError(err)
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(c); err != nil {
+			return nil, fmt.Errorf("wurfl: cannot parse configuration file %s: %w", path, err)
+		}
+	}
+	if err := c.ApplyEnv(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ApplyEnv overrides the configuration with the WURFL_* environment variables that are set:
+//
+//	WURFL_DATA_FILE, WURFL_PATCHES, WURFL_CAPABILITY_FILTER, WURFL_DETECTION_CAPS (comma
+//	separated lists), WURFL_CACHE_PROVIDER, WURFL_CACHE_SIZE, WURFL_LOG_PATH,
+//	WURFL_CAPABILITY_FALLBACK_CACHE, WURFL_VALIDATE_DATA_FILE, WURFL_CANARY_CORPUS, WURFL_FALLBACK_DEVICE_ID,
+//	WURFL_MAX_CONCURRENT_LOOKUPS, WURFL_LOOKUP_QUEUE_SIZE, WURFL_LOOKUP_QUEUE_TIMEOUT_MS,
+//	WURFL_UPDATER_DATA_URL, WURFL_UPDATER_FREQUENCY, WURFL_UPDATER_CONNECTION_TIMEOUT_MS,
+//	WURFL_UPDATER_DATA_TRANSFER_TIMEOUT_MS, WURFL_UPDATER_LOG_PATH, WURFL_UPDATER_USER_AGENT,
+//	WURFL_UPDATER_START
+//
+// Values that cannot be parsed are all reported in a *ConfigError.
+func (c *Config) ApplyEnv() error {
+	var fields []*FieldError
+
+	envString := func(name string, dst *string) {
+		if v, ok := os.LookupEnv(name); ok {
+			*dst = v
+		}
+	}
+	envList := func(name string, dst *[]string) {
+		if v, ok := os.LookupEnv(name); ok {
+			*dst = splitList(v)
+		}
+	}
+	envBool := func(name string, dst *bool) {
+		if v, ok := os.LookupEnv(name); ok {
+			b, err := strconv.ParseBool(strings.TrimSpace(v))
+			if err != nil {
+				fields = append(fields, &FieldError{Field: name, Err: ErrInvalidParameter})
+				return
+			}
+			*dst = b
+		}
+	}
+	envInt := func(name string, set func(int)) {
+		if v, ok := os.LookupEnv(name); ok {
+			n, err := strconv.Atoi(strings.TrimSpace(v))
+			if err != nil {
+				fields = append(fields, &FieldError{Field: name, Err: ErrInvalidParameter})
+				return
+			}
+			set(n)
+		}
+	}
+
+	envString("WURFL_DATA_FILE", &c.DataFile)
+	envList("WURFL_PATCHES", &c.Patches)
+	envList("WURFL_CAPABILITY_FILTER", &c.CapabilityFilter)
+	envString("WURFL_CACHE_PROVIDER", &c.CacheProvider)
+	envInt("WURFL_CACHE_SIZE", func(n int) { c.CacheSize = n })
+	envString("WURFL_LOG_PATH", &c.LogPath)
+	envString("WURFL_CAPABILITY_FALLBACK_CACHE", &c.CapabilityFallbackCache)
+	envString("WURFL_UPDATER_DATA_URL", &c.Updater.DataURL)
+	envString("WURFL_UPDATER_FREQUENCY", &c.Updater.Frequency)
+	envInt("WURFL_UPDATER_CONNECTION_TIMEOUT_MS", func(n int) { c.Updater.ConnectionTimeout = &n })
+	envInt("WURFL_UPDATER_DATA_TRANSFER_TIMEOUT_MS", func(n int) { c.Updater.DataTransferTimeout = &n })
+	envString("WURFL_UPDATER_LOG_PATH", &c.Updater.LogPath)
+	envString("WURFL_UPDATER_USER_AGENT", &c.Updater.UserAgent)
+	envBool("WURFL_VALIDATE_DATA_FILE", &c.ValidateDataFile)
+	envString("WURFL_CANARY_CORPUS", &c.CanaryCorpus)
+	envString("WURFL_FALLBACK_DEVICE_ID", &c.FallbackDeviceID)
+	envList("WURFL_DETECTION_CAPS", &c.DetectionCaps)
+	envInt("WURFL_MAX_CONCURRENT_LOOKUPS", func(n int) { c.LookupLimit.MaxConcurrentLookups = n })
+	envInt("WURFL_LOOKUP_QUEUE_SIZE", func(n int) { c.LookupLimit.QueueSize = &n })
+	envInt("WURFL_LOOKUP_QUEUE_TIMEOUT_MS", func(n int) { c.LookupLimit.QueueTimeout = n })
+	envBool("WURFL_UPDATER_START", &c.Updater.Start)
+
+	if len(fields) != 0 {
+		return &ConfigError{Fields: fields}
+	}
+	return nil
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(v string) []string {
+	var result []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// configOption binds an Option to the Config field it was built from
+type configOption struct {
+	field  string
+	option Option
+}
+
+// fieldOf maps the Option names reported by options.validate to Config fields
+var fieldOf = map[string]string{
+	"WithCacheSize":    "cache_size",
+	"WithUpdaterStart": "updater.start",
+	"WithLookupQueue":  "lookup_limit.queue_size",
+}
+
+// Validate checks the whole configuration, and returns a *ConfigError listing every invalid field.
+func (c *Config) Validate() error {
+	_, _, err := c.options()
+	return err
+}
+
+// Options validates the configuration and converts it to the equivalent CreateWithOptions options.
+func (c *Config) Options() ([]Option, error) {
+	opts, _, err := c.options()
+	return opts, err
+}
+
+// options converts the configuration to options and applies them, once: the corpus and
+// patch checks of the options read the filesystem. It returns the options and their result.
+func (c *Config) options() ([]Option, *options, error) {
+	var fields []*FieldError
+	var copts []configOption
+
+	if c.DataFile == "" {
+		fields = append(fields, &FieldError{Field: "data_file", Err: ErrRootNotSet})
+	}
+	if len(c.Patches) != 0 {
+		copts = append(copts, configOption{"patches", WithPatches(c.Patches...)})
+	}
+	if len(c.CapabilityFilter) != 0 {
+		copts = append(copts, configOption{"capability_filter", WithCapabilityFilter(c.CapabilityFilter...)})
+	}
+	switch strings.ToLower(c.CacheProvider) {
+	case "":
+	case "none":
+		copts = append(copts, configOption{"cache_provider", WithCacheProvider(WurflCacheProviderNone)})
+	case "lru":
+		copts = append(copts, configOption{"cache_provider", WithCacheProvider(WurflCacheProviderLru)})
+	default:
+		fields = append(fields, &FieldError{Field: "cache_provider", Err: ErrInvalidParameter})
+	}
+	if c.CacheSize != 0 {
+		copts = append(copts, configOption{"cache_size", WithCacheSize(c.CacheSize)})
+	}
+	if c.LogPath != "" {
+		copts = append(copts, configOption{"log_path", WithLogPath(c.LogPath)})
+	}
+	switch strings.ToLower(c.CapabilityFallbackCache) {
+	case "":
+	case "default":
+		copts = append(copts, configOption{"capability_fallback_cache", WithAttr(WurflAttrCapabilityFallbackCache, WurflAttrCapabilityFallbackCacheDefault)})
+	case "disabled":
+		copts = append(copts, configOption{"capability_fallback_cache", WithAttr(WurflAttrCapabilityFallbackCache, WurflAttrCapabilityFallbackCacheDisabled)})
+	case "limited":
+		copts = append(copts, configOption{"capability_fallback_cache", WithAttr(WurflAttrCapabilityFallbackCache, WurflAttrCapabilityFallbackCacheLimited)})
+	default:
+		fields = append(fields, &FieldError{Field: "capability_fallback_cache", Err: ErrInvalidParameter})
+	}
+	if c.ValidateDataFile {
+		copts = append(copts, configOption{"validate_data_file", WithDataFileValidation()})
+	}
+	if c.CanaryCorpus != "" {
+		copts = append(copts, configOption{"canary_corpus", WithCanaryCorpusFile(c.CanaryCorpus)})
+	}
+	if c.FallbackDeviceID != "" {
+		copts = append(copts, configOption{"fallback_device_id", WithFallbackDevice(c.FallbackDeviceID)})
+	}
+	if len(c.DetectionCaps) != 0 {
+		copts = append(copts, configOption{"detection_caps", WithDetectionCaps(c.DetectionCaps...)})
+	}
+
+	l := c.LookupLimit
+	if l.MaxConcurrentLookups != 0 {
+		copts = append(copts, configOption{"lookup_limit.max_concurrent_lookups", WithMaxConcurrentLookups(l.MaxConcurrentLookups)})
+	}
+	if l.QueueSize != nil || l.QueueTimeout != 0 {
+		size := -1
+		if l.QueueSize != nil {
+			size = *l.QueueSize
+		}
+		copts = append(copts, configOption{"lookup_limit.queue_timeout_ms", WithLookupQueue(size, time.Duration(l.QueueTimeout)*time.Millisecond)})
+	}
+
+	u := c.Updater
+	if u.DataURL != "" {
+		copts = append(copts, configOption{"updater.data_url", WithUpdaterDataURL(u.DataURL)})
+	}
+	switch strings.ToLower(u.Frequency) {
+	case "":
+	case "daily":
+		copts = append(copts, configOption{"updater.frequency", WithUpdaterFrequency(WurflUpdaterFrequencyDaily)})
+	case "weekly":
+		copts = append(copts, configOption{"updater.frequency", WithUpdaterFrequency(WurflUpdaterFrequencyWeekly)})
+	default:
+		fields = append(fields, &FieldError{Field: "updater.frequency", Err: ErrInvalidParameter})
+	}
+	if u.ConnectionTimeout != nil || u.DataTransferTimeout != nil {
+		conn, data := -1, -1
+		if u.ConnectionTimeout != nil {
+			conn = *u.ConnectionTimeout
+		}
+		if u.DataTransferTimeout != nil {
+			data = *u.DataTransferTimeout
+		}
+		copts = append(copts, configOption{"updater.connection_timeout_ms", WithUpdaterTimeouts(conn, data)})
+	}
+	if u.LogPath != "" {
+		copts = append(copts, configOption{"updater.log_path", WithUpdaterLogPath(u.LogPath)})
+	}
+	if u.UserAgent != "" {
+		copts = append(copts, configOption{"updater.user_agent", WithUpdaterUserAgent(u.UserAgent)})
+	}
+	if u.Start {
+		copts = append(copts, configOption{"updater.start", WithUpdaterStart()})
+	}
+
+	// run every option, collecting all the failures
+	o := defaultOptions()
+	opts := make([]Option, 0, len(copts))
+	for _, co := range copts {
+		if err := co.option(o); err != nil {
+			fields = append(fields, &FieldError{Field: co.field, Err: unwrapOptionError(err)})
+		}
+		opts = append(opts, co.option)
+	}
+	if err := o.validate(); err != nil {
+		field := "unknown"
+		var optErr *OptionError
+		if errors.As(err, &optErr) {
+			field = optErr.Option
+			if f, ok := fieldOf[optErr.Option]; ok {
+				field = f
+			}
+		}
+		fields = append(fields, &FieldError{Field: field, Err: unwrapOptionError(err)})
+	}
+
+	if len(fields) != 0 {
+		return nil, nil, &ConfigError{Fields: fields}
+	}
+	return opts, o, nil
+}
+
+// unwrapOptionError returns the error wrapped in an *OptionError, or err itself
+func unwrapOptionError(err error) error {
+	var optErr *OptionError
+	if errors.As(err, &optErr) {
+		return optErr.Err
+	}
+	return err
+}
+
+// CreateFromConfig validates c and creates the engine it describes, starting the updater if requested.
+func CreateFromConfig(c *Config) (*Wurfl, error) {
+	_, o, err := c.options()
+	if err != nil {
+		return nil, err
+	}
+	return create(c.DataFile, o)
+}
+
+// Config returns a snapshot of the configuration the engine is running with: the data
+// file and patches of the last load, the options it was created with and the changes
+// made by the setters since then. The updater data URL token is redacted; use
+// UnredactedConfig to get it.
+func (w *Wurfl) Config() (*Config, error) {
+	c, err := w.UnredactedConfig()
+	if err != nil {
+		return nil, err
+	}
+	c.Updater.DataURL = redactURL(c.Updater.DataURL)
+	return c, nil
+}
+
+// UnredactedConfig is like Config, including the updater data URL as is.
+func (w *Wurfl) UnredactedConfig() (*Config, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	e := w.acquire()
+	if e == nil {
+		return nil, ErrEngineClosed
+	}
+	defer e.release()
+
+	o := w.opts
+	c := &Config{
+		DataFile:         e.root,
+		CapabilityFilter: append([]string(nil), o.capFilter...),
+		LogPath:          o.logPath,
+		ValidateDataFile: o.validateFiles,
+		CanaryCorpus:     o.corpusPath,
+		FallbackDeviceID: o.fallbackDeviceID,
+		DetectionCaps:    append([]string(nil), o.detectionCaps...),
+	}
+	for _, p := range o.patches {
+		c.Patches = append(c.Patches, p.path)
+	}
+
+	switch o.cacheProvider {
+	case WurflCacheProviderNone:
+		c.CacheProvider = "none"
+	case WurflCacheProviderLru, WurflCacheProviderDoubleLru:
+		c.CacheProvider = "lru"
+		// the double lru extra config is "size1,size2"
+		size, _, _ := strings.Cut(o.cacheExtraConfig, ",")
+		c.CacheSize, _ = strconv.Atoi(strings.TrimSpace(size))
+	}
+
+	// report the fallback cache in use even when it was never set
+	if requireFeature(FeatureCapabilityFallbackCache) == nil {
+		if value, err := e.getAttr(WurflAttrCapabilityFallbackCache); err == nil {
+			switch value {
+			case WurflAttrCapabilityFallbackCacheDefault:
+				c.CapabilityFallbackCache = "default"
+			case WurflAttrCapabilityFallbackCacheDisabled:
+				c.CapabilityFallbackCache = "disabled"
+			case WurflAttrCapabilityFallbackCacheLimited:
+				c.CapabilityFallbackCache = "limited"
+			}
+		}
+	}
+
+	c.LookupLimit.MaxConcurrentLookups = o.limit.maxLookups
+	if o.limit.queueSet {
+		size := o.limit.queueSize
+		c.LookupLimit.QueueSize = &size
+		c.LookupLimit.QueueTimeout = int(o.limit.queueTimeout / time.Millisecond)
+	}
+
+	c.Updater = UpdaterConfig{
+		DataURL:   o.updaterDataURL,
+		LogPath:   o.updaterLogPath,
+		UserAgent: o.updaterUserAgent,
+		Start:     o.updaterStart,
+	}
+	if o.updaterFrequencySet {
+		switch o.updaterFrequency {
+		case WurflUpdaterFrequencyDaily:
+			c.Updater.Frequency = "daily"
+		case WurflUpdaterFrequencyWeekly:
+			c.Updater.Frequency = "weekly"
+		}
+	}
+	if o.updaterTimeoutsSet {
+		conn, data := o.updaterConnTimeout, o.updaterTransferTimeout
+		c.Updater.ConnectionTimeout = &conn
+		c.Updater.DataTransferTimeout = &data
+	}
+	return c, nil
+}
+
+// redactedToken replaces the secret parts of a redacted URL
+const redactedToken = "xxxxx"
+
+// redactURL hides the secrets of a snapshot URL: the user info, the query and every path
+// segment but the file name, ie: https://data.scientiamobile.com/xxxxx/wurfl.zip
+func redactURL(s string) string {
+	if s == "" {
+		return ""
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return redactedToken
+	}
+	if u.User != nil {
+		u.User = url.User(redactedToken)
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = redactedToken
+	}
+	segments := strings.Split(strings.TrimPrefix(u.Path, "/"), "/")
+	for i := 0; i < len(segments)-1; i++ {
+		if segments[i] != "" {
+			segments[i] = redactedToken
+		}
+	}
+	u.Path = "/" + strings.Join(segments, "/")
+	u.RawPath = ""
+	return u.String()
+}
@@ -0,0 +1,190 @@
+package wurfl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+)
+
+// The libwurfl calls cannot be interrupted: a context lookup runs the lookup on another
+// goroutine and stops waiting for it when the context ends. The abandoned lookup still
+// runs to completion, holding its engine like any call in progress, and the Device it
+// returns is destroyed right away.
+
+// lookup states of a context lookup, see lookupContext
+const (
+	lookupRunning   = iota
+	lookupDone      // the lookup has returned, its result is for the caller
+	lookupAbandoned // the caller has given up, the lookup destroys its result
+)
+
+// WithFallbackDevice sets the device returned by the context lookups (LookupRequestContext,
+// LookupUserAgentContext, LookupWithImportantHeaderMapContext) when their context ends
+// before the lookup is done, instead of failing with the context error. The device id is
+// checked when the engine is loaded; the fallback Device must be destroyed like any other
+// and its IsFallback method returns true.
+func WithFallbackDevice(deviceID string) Option {
+	return func(o *options) error {
+		if deviceID == "" {
+			return &OptionError{Option: "WithFallbackDevice", Err: ErrInvalidParameter}
+		}
+		o.fallbackDeviceID = deviceID
+		return nil
+	}
+}
+
+// checkFallbackDevice makes sure the fallback device of an engine that is not installed in
+// a Wurfl yet exists
+func (e *engine) checkFallbackDevice(deviceID string) error {
+	device, err := e.lookupDeviceID(deviceID)
+	if err != nil {
+		return &OptionError{Option: "WithFallbackDevice", Err: err}
+	}
+	device.Destroy()
+	return nil
+}
+
+// IsFallback reports whether d is the fallback device returned by a context lookup whose
+// context ended, see WithFallbackDevice
+func (d *Device) IsFallback() bool {
+	return d.fallback
+}
+
+// LookupRequestContext is like LookupRequest, giving up when ctx ends: it then returns the
+// fallback device if one is configured (see WithFallbackDevice), ctx.Err() otherwise.
+// The important headers of r are copied before the lookup starts: r can be modified as
+// soon as LookupRequestContext has returned.
+func (w *Wurfl) LookupRequestContext(ctx context.Context, r *http.Request) (*Device, error) {
+	snapshot := &http.Request{Header: w.importantHeaders(r.Header)}
+	return w.lookupContext(ctx, func() (*Device, error) {
+		return w.lookupRequest(ctx, snapshot)
+	})
+}
+
+// importantHeaders returns a copy of the important headers of h, with the single value
+// LookupRequest reads, for a lookup that may outlive the caller
+func (w *Wurfl) importantHeaders(h http.Header) http.Header {
+	e := w.acquire()
+	if e == nil {
+		// the lookup fails with ErrEngineClosed
+		return nil
+	}
+	defer e.release()
+
+	names := e.headers.Load().names
+	snapshot := make(http.Header, len(names))
+	for _, name := range names {
+		if value := h.Get(name); value != "" {
+			snapshot.Set(name, value)
+		}
+	}
+	return snapshot
+}
+
+// LookupUserAgentContext is like LookupUserAgent, giving up when ctx ends: it then returns
+// the fallback device if one is configured (see WithFallbackDevice), ctx.Err() otherwise.
+func (w *Wurfl) LookupUserAgentContext(ctx context.Context, ua string) (*Device, error) {
+	return w.lookupContext(ctx, func() (*Device, error) {
+		return w.lookupUserAgent(ctx, ua)
+	})
+}
+
+// LookupWithImportantHeaderMapContext is like LookupWithImportantHeaderMap, giving up when
+// ctx ends: it then returns the fallback device if one is configured (see
+// WithFallbackDevice), ctx.Err() otherwise. The important headers of IHMap are copied
+// before the lookup starts: IHMap can be modified as soon as the call has returned.
+func (w *Wurfl) LookupWithImportantHeaderMapContext(ctx context.Context, IHMap map[string]string) (*Device, error) {
+	snapshot := w.importantHeaderMap(IHMap)
+	return w.lookupContext(ctx, func() (*Device, error) {
+		return w.lookupWithImportantHeaderMap(ctx, snapshot)
+	})
+}
+
+// importantHeaderMap returns a copy of the important headers of IHMap, for a lookup that
+// may outlive the caller
+func (w *Wurfl) importantHeaderMap(IHMap map[string]string) map[string]string {
+	e := w.acquire()
+	if e == nil {
+		// the lookup fails with ErrEngineClosed
+		return nil
+	}
+	defer e.release()
+
+	hs := e.headers.Load()
+	snapshot := make(map[string]string, len(hs.names))
+	for name, value := range IHMap {
+		if _, found := hs.trie.get(name); found {
+			snapshot[name] = value
+		}
+	}
+	return snapshot
+}
+
+type lookupResult struct {
+	device *Device
+	err    error
+}
+
+// lookupContext runs lookup until ctx ends. Whichever of the lookup and the caller
+// changes the state first owns the Device: a lookup finishing after the caller has
+// given up destroys it.
+func (w *Wurfl) lookupContext(ctx context.Context, lookup func() (*Device, error)) (*Device, error) {
+	if err := ctx.Err(); err != nil {
+		return w.fallback(err)
+	}
+	// a context that never ends does not need a goroutine
+	if ctx.Done() == nil {
+		return lookup()
+	}
+
+	var state atomic.Int32
+	done := make(chan lookupResult, 1)
+	go func() {
+		device, err := lookup()
+		if !state.CompareAndSwap(lookupRunning, lookupDone) {
+			if err == nil {
+				device.Destroy()
+			}
+			return
+		}
+		done <- lookupResult{device, err}
+	}()
+
+	var r lookupResult
+	select {
+	case r = <-done:
+	case <-ctx.Done():
+		if state.CompareAndSwap(lookupRunning, lookupAbandoned) {
+			return w.fallback(ctx.Err())
+		}
+		// the lookup is done and its result on the way: keep it
+		r = <-done
+	}
+	// the lookup may have given up waiting for the lookup limiter
+	if r.err != nil && errors.Is(r.err, ctx.Err()) {
+		return w.fallback(r.err)
+	}
+	return r.device, r.err
+}
+
+// fallback returns the fallback device of the current engine, or err if there is none. The
+// lookup of the fallback device does not wait for the limiter: it would make a lookup whose
+// context has ended wait again, or fail with ErrLookupQueueFull.
+func (w *Wurfl) fallback(err error) (*Device, error) {
+	e := w.acquire()
+	if e == nil {
+		return nil, ErrEngineClosed
+	}
+	defer e.release()
+
+	if e.fallbackDeviceID == "" {
+		return nil, err
+	}
+	device, lerr := e.lookupDeviceID(e.fallbackDeviceID)
+	if lerr != nil {
+		return nil, err
+	}
+	device.fallback = true
+	return device, nil
+}
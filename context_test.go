@@ -0,0 +1,183 @@
+package wurfl_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	wurfl "github.com/WURFL/golang-wurfl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWurfl_LookupContext(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+	defer wengine.Destroy()
+
+	ua := "ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0"
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	req.Header.Set("User-Agent", ua)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	lookups := map[string]func(context.Context) (*wurfl.Device, error){
+		"LookupRequestContext": func(ctx context.Context) (*wurfl.Device, error) {
+			return wengine.LookupRequestContext(ctx, req)
+		},
+		"LookupUserAgentContext": func(ctx context.Context) (*wurfl.Device, error) {
+			return wengine.LookupUserAgentContext(ctx, ua)
+		},
+		"LookupWithImportantHeaderMapContext": func(ctx context.Context) (*wurfl.Device, error) {
+			return wengine.LookupWithImportantHeaderMapContext(ctx, map[string]string{"User-Agent": ua})
+		},
+	}
+	for name, lookup := range lookups {
+		for _, ctx := range []context.Context{ctx, context.Background()} {
+			device, err := lookup(ctx)
+			require.NoError(t, err, name)
+			id, err := device.GetDeviceID()
+			assert.NoError(t, err, name)
+			assert.Equal(t, "apple_iphone_ver8_3_subuacfnetwork", id, name)
+			assert.False(t, device.IsFallback(), name)
+			device.Destroy()
+		}
+
+		expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		device, err := lookup(expired)
+		cancel()
+		assert.Nil(t, device, name)
+		assert.ErrorIs(t, err, context.DeadlineExceeded, name)
+	}
+}
+
+func TestWithFallbackDevice(t *testing.T) {
+	wengine, err := wurfl.CreateWithOptions(fixtureWurflZip(), wurfl.WithFallbackDevice("generic"))
+	require.NoError(t, err)
+	defer wengine.Destroy()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	device, err := wengine.LookupUserAgentContext(ctx, "ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	require.NoError(t, err)
+	assert.True(t, device.IsFallback())
+	id, err := device.GetDeviceID()
+	assert.NoError(t, err)
+	assert.Equal(t, "generic", id)
+	device.Destroy()
+
+	// the fallback device survives a Reload
+	require.NoError(t, wengine.Reload(fixtureWurflZip(), nil))
+	device, err = wengine.LookupUserAgentContext(ctx, "ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	require.NoError(t, err)
+	assert.True(t, device.IsFallback())
+	device.Destroy()
+
+	c, err := wengine.Config()
+	require.NoError(t, err)
+	assert.Equal(t, "generic", c.FallbackDeviceID)
+}
+
+func TestWithFallbackDevice_Invalid(t *testing.T) {
+	_, err := wurfl.CreateWithOptions(fixtureWurflZip(), wurfl.WithFallbackDevice(""))
+	var optErr *wurfl.OptionError
+	require.ErrorAs(t, err, &optErr)
+	assert.Equal(t, "WithFallbackDevice", optErr.Option)
+	assert.ErrorIs(t, err, wurfl.ErrInvalidParameter)
+
+	before := wurfl.Handles()
+	_, err = wurfl.CreateWithOptions(fixtureWurflZip(), wurfl.WithFallbackDevice("not_a_device_id"))
+	require.ErrorAs(t, err, &optErr)
+	assert.Equal(t, "WithFallbackDevice", optErr.Option)
+	assert.Equal(t, before.LiveEngines, wurfl.Handles().LiveEngines)
+}
+
+// TestWurfl_LookupContext_Abandoned cancels lookups while they run: the Devices of the
+// lookups finishing after their caller has given up must be destroyed.
+func TestWurfl_LookupContext_Abandoned(t *testing.T) {
+	wengine, err := wurfl.CreateWithOptions(fixtureWurflZip(), wurfl.WithFallbackDevice("generic"))
+	require.NoError(t, err)
+	defer wengine.Destroy()
+
+	before := wurfl.Handles()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				ctx, cancel := context.WithCancel(context.Background())
+				go cancel()
+				device, err := wengine.LookupUserAgentContext(ctx, "ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+				if err != nil && !errors.Is(err, context.Canceled) {
+					t.Errorf("LookupUserAgentContext: unexpected error %v", err)
+				}
+				device.Destroy()
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Eventually(t, func() bool { return wurfl.Handles().LiveDevices == before.LiveDevices },
+		time.Second, time.Millisecond)
+}
+
+func TestWurfl_LookupRequestContext_Abandoned(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+	defer wengine.Destroy()
+
+	// the request is reused as soon as the lookup returns, even if an abandoned lookup is
+	// still running: go test -race reports it if the lookup reads it
+	r, err := http.NewRequest(http.MethodGet, "http://localhost/", nil)
+	require.NoError(t, err)
+	for i := 0; i < 200; i++ {
+		r.Header.Set("User-Agent", "ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+		ctx, cancel := context.WithCancel(context.Background())
+		go cancel()
+		device, err := wengine.LookupRequestContext(ctx, r)
+		if err == nil {
+			device.Destroy()
+		}
+		r.Header.Del("User-Agent")
+		cancel()
+	}
+}
+
+func TestWurfl_LookupWithImportantHeaderMapContext_Abandoned(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+	defer wengine.Destroy()
+
+	// the map is cleared as soon as the lookup returns, even if an abandoned lookup is
+	// still running: a lookup reading it would be a concurrent map read and write
+	headers := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		headers["User-Agent"] = "ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0"
+		ctx, cancel := context.WithCancel(context.Background())
+		go cancel()
+		device, err := wengine.LookupWithImportantHeaderMapContext(ctx, headers)
+		if err == nil {
+			device.Destroy()
+		}
+		clear(headers)
+		cancel()
+	}
+}
+
+func TestWurfl_LookupContext_Closed(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+	wengine.Destroy()
+
+	_, err := wengine.LookupUserAgentContext(context.Background(), "ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	assert.ErrorIs(t, err, wurfl.ErrEngineClosed)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = wengine.LookupUserAgentContext(ctx, "ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	assert.ErrorIs(t, err, wurfl.ErrEngineClosed)
+}
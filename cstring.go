@@ -0,0 +1,34 @@
+//go:build !wurfl_audit
+
+package wurfl
+
+//
+//#include <stdlib.h>
+import "C"
+
+import (
+	"unsafe"
+)
+
+// cString, cMalloc and cFree are the only way the package allocates and frees C memory, so
+// that the wurfl_audit build tag can replace them with an auditing allocator (see
+// cstring_audit.go). The header values of the lookups are copied to a C buffer allocated
+// with cMalloc, see arena.go.
+
+// auditing reports whether the wurfl_audit allocator is used
+const auditing = false
+
+// cString returns a C copy of s, to be released with cFree
+func cString(s string) *C.char {
+	return C.CString(s)
+}
+
+// cMalloc returns n bytes of uninitialized C memory, to be released with cFree
+func cMalloc(n int) *C.char {
+	return (*C.char)(C.malloc(C.size_t(n)))
+}
+
+// cFree releases a C string returned by cString, or memory returned by cMalloc
+func cFree(p *C.char) {
+	C.free(unsafe.Pointer(p))
+}
@@ -0,0 +1,143 @@
+//go:build wurfl_audit
+
+package wurfl
+
+//
+//#include <stdlib.h>
+import "C"
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// The wurfl_audit build tag replaces the C string allocator of the package with an auditing
+// one, for tests (go test -tags wurfl_audit):
+//   - every allocation is recorded with its stack, see CStringAudit
+//   - freeing a C string twice, or one not allocated by the package, panics with the stacks
+//   - a freed C string is poisoned and never given back to the C allocator, so a use after
+//     free reads garbage instead of a plausible value, and a write after free is reported
+//   - the header arenas are allocated with cMalloc and freed on release instead of being
+//     pooled, so a header value used after its lookup reads the poison too
+//
+// Memory is never released in this mode: do not use it in production.
+
+// poison fills the freed C strings, up to their terminating NUL
+const poison = 0xDD
+
+type cstringAlloc struct {
+	size    int // including the terminating NUL
+	created *allocation
+	freed   *allocation
+}
+
+var cstringAudit struct {
+	mu         sync.Mutex
+	live       map[*C.char]*cstringAlloc
+	quarantine map[*C.char]*cstringAlloc
+	allocated  int64
+	freed      int64
+}
+
+func init() {
+	cstringAudit.live = make(map[*C.char]*cstringAlloc)
+	cstringAudit.quarantine = make(map[*C.char]*cstringAlloc)
+}
+
+// auditing reports whether the wurfl_audit allocator is used
+const auditing = true
+
+// cString returns a C copy of s, to be released with cFree
+func cString(s string) *C.char {
+	p := C.CString(s)
+	record(p, len(s)+1)
+	return p
+}
+
+// cMalloc returns n bytes of uninitialized C memory, to be released with cFree
+func cMalloc(n int) *C.char {
+	p := (*C.char)(C.malloc(C.size_t(n)))
+	record(p, n)
+	return p
+}
+
+// record adds the size bytes at p to the live allocations, with the stack of the caller of
+// cString or cMalloc
+func record(p *C.char, size int) {
+	a := &cstringAlloc{size: size, created: newAllocation(1)}
+
+	cstringAudit.mu.Lock()
+	defer cstringAudit.mu.Unlock()
+	cstringAudit.live[p] = a
+	cstringAudit.allocated++
+}
+
+// cFree poisons a C string returned by cString, or memory returned by cMalloc, and moves
+// it to the quarantine
+func cFree(p *C.char) {
+	cstringAudit.mu.Lock()
+	defer cstringAudit.mu.Unlock()
+
+	a, ok := cstringAudit.live[p]
+	if !ok {
+		if q, freed := cstringAudit.quarantine[p]; freed {
+			panic(fmt.Sprintf("wurfl: double free of a C string allocated at:\n%s\nfirst freed at:\n%s\nfreed again at:\n%s",
+				q.created, q.freed, newAllocation(0)))
+		}
+		panic(fmt.Sprintf("wurfl: free of a C string not allocated by cString at:\n%s", newAllocation(0)))
+	}
+
+	a.freed = newAllocation(0)
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(p)), a.size)
+	for i := range buf[:a.size-1] {
+		buf[i] = poison
+	}
+	delete(cstringAudit.live, p)
+	cstringAudit.quarantine[p] = a
+	cstringAudit.freed++
+}
+
+// CStringAuditReport is the state of the C string allocator of the package, only available
+// with the wurfl_audit build tag
+type CStringAuditReport struct {
+	Allocated        int64          // C strings allocated so far
+	Freed            int64          // C strings freed so far
+	Outstanding      map[string]int // number of C strings not freed yet, by creation stack
+	WrittenAfterFree []string       // creation and free stacks of the freed C strings modified since
+}
+
+// Live returns the number of C strings not freed yet
+func (r *CStringAuditReport) Live() int {
+	n := 0
+	for _, count := range r.Outstanding {
+		n += count
+	}
+	return n
+}
+
+// CStringAudit returns the C strings not freed yet and checks the freed ones are untouched
+func CStringAudit() *CStringAuditReport {
+	cstringAudit.mu.Lock()
+	defer cstringAudit.mu.Unlock()
+
+	r := &CStringAuditReport{
+		Allocated:   cstringAudit.allocated,
+		Freed:       cstringAudit.freed,
+		Outstanding: make(map[string]int),
+	}
+	for _, a := range cstringAudit.live {
+		r.Outstanding[a.created.String()]++
+	}
+	for p, a := range cstringAudit.quarantine {
+		buf := unsafe.Slice((*byte)(unsafe.Pointer(p)), a.size)
+		for _, b := range buf[:a.size-1] {
+			if b != poison {
+				r.WrittenAfterFree = append(r.WrittenAfterFree,
+					fmt.Sprintf("allocated at:\n%s\nfreed at:\n%s", a.created, a.freed))
+				break
+			}
+		}
+	}
+	return r
+}
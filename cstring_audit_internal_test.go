@@ -0,0 +1,47 @@
+//go:build wurfl_audit
+
+package wurfl
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCStringAudit_DoubleFree(t *testing.T) {
+	p := cString("brand_name")
+	cFree(p)
+	assert.Panics(t, func() { cFree(p) })
+}
+
+func TestCStringAudit_WriteAfterFree(t *testing.T) {
+	p := cString("brand_name")
+	cFree(p)
+	assert.Equal(t, byte(poison), *(*byte)(unsafe.Pointer(p)))
+
+	*(*byte)(unsafe.Pointer(p)) = 'b'
+	r := CStringAudit()
+	require.Len(t, r.WrittenAfterFree, 1)
+	assert.Contains(t, r.WrittenAfterFree[0], "TestCStringAudit_WriteAfterFree")
+
+	// restore the poison for the other tests
+	*(*byte)(unsafe.Pointer(p)) = poison
+	assert.Empty(t, CStringAudit().WrittenAfterFree)
+}
+
+func TestCStringAudit_HeaderArena(t *testing.T) {
+	before := CStringAudit()
+
+	a := getHeaderArena()
+	p := a.cString("Mozilla/5.0")
+	assert.Equal(t, before.Allocated+1, CStringAudit().Allocated)
+
+	// arenas are not pooled when auditing: a value used after release reads the poison
+	a.release()
+	assert.Equal(t, byte(poison), *(*byte)(unsafe.Pointer(p)))
+	after := CStringAudit()
+	assert.Equal(t, before.Freed+1, after.Freed)
+	assert.Equal(t, before.Live(), after.Live())
+}
@@ -0,0 +1,74 @@
+//go:build wurfl_audit
+
+package wurfl_test
+
+import (
+	"testing"
+
+	wurfl "github.com/WURFL/golang-wurfl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// run with: go test -tags wurfl_audit -run CStringAudit
+
+func TestCStringAudit_NoLeaks(t *testing.T) {
+	before := wurfl.CStringAudit()
+
+	wengine := fixtureCreateEngine(t)
+	device, err := wengine.LookupUserAgent("ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	require.NoError(t, err)
+
+	_, err = device.GetStaticCap("brand_name")
+	assert.NoError(t, err)
+	_, err = device.GetVirtualCap("is_android")
+	assert.NoError(t, err)
+	_, err = device.GetCapabilityAsInt("resolution_width")
+	assert.NoError(t, err)
+	// names not in the cache are allocated for the call, and must stay valid until the C call returns
+	_, err = device.GetCapabilityAsInt("not_a_capability")
+	assert.ErrorIs(t, err, wurfl.ErrCapabilityNotFound)
+	_, err = device.GetVirtualCapabilityAsInt("not_a_virtual_capability")
+	assert.Error(t, err)
+	_, err = device.GetStaticCaps([]string{"brand_name", "not_a_capability"})
+	assert.Error(t, err)
+
+	device2, err := wengine.LookupWithImportantHeaderMap(map[string]string{"User-Agent": "Mozilla/5.0"})
+	require.NoError(t, err)
+	device2.Destroy()
+	device2, err = wengine.LookupHeaderSource(wurfl.HeaderSourceFunc(func(name string) []byte {
+		return []byte(name + ": Mozilla/5.0")
+	}))
+	require.NoError(t, err)
+	device2.Destroy()
+	_, err = wengine.LookupDeviceID("not_a_device_id")
+	assert.Error(t, err)
+
+	device.Destroy()
+	wengine.Destroy()
+
+	after := wurfl.CStringAudit()
+	assert.Equal(t, before.Live(), after.Live(), "outstanding C strings: %v", after.Outstanding)
+	assert.Empty(t, after.WrittenAfterFree)
+}
+
+func TestCStringAudit_CachedCapabilityNames(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+	defer wengine.Destroy()
+
+	device, err := wengine.LookupUserAgent("ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	require.NoError(t, err)
+	defer device.Destroy()
+
+	before := wurfl.CStringAudit().Allocated
+	for _, vcap := range wengine.GetAllVCaps() {
+		_, err := device.GetVirtualCap(vcap)
+		assert.NoError(t, err, vcap)
+	}
+	for _, cap := range wengine.GetAllCaps() {
+		_, err := device.GetStaticCap(cap)
+		assert.NoError(t, err, cap)
+	}
+	// static and virtual capability names are cached in C by the engine
+	assert.Equal(t, before, wurfl.CStringAudit().Allocated)
+}
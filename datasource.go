@@ -0,0 +1,223 @@
+package wurfl
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// The data file of CreateFromBytes, CreateFromReader and CreateFromFS, and the patches of
+// WithPatchBytes and WithPatchFS, are staged into a private temporary directory (created
+// under os.TempDir(), readable by the current user only) and loaded from there with
+// wurfl_set_root and wurfl_add_patch: wurfl.h declares no function loading them from
+// memory. The directory is kept for the whole engine lifetime, as the updater replaces the
+// root file in place, and removed by Destroy.
+
+// memoryPatch is the path reported by Config for the patch of WithPatchBytes at index i of
+// the patches
+func memoryPatch(i int) string {
+	return "<memory #" + strconv.Itoa(i) + ">"
+}
+
+// patchSource is a patch file passed to WithPatches, WithPatchBytes or WithPatchFS
+type patchSource struct {
+	path   string                        // file path, used as is when open is nil
+	open   func() (io.ReadCloser, error) // in-memory or fs.FS patch, staged before load
+	option string                        // option that added the patch, reported by staging errors
+}
+
+// detectDataFormat returns the file extension matching the data format, recognizing the
+// formats accepted by libwurfl: zip, gzip and plain xml.
+func detectDataFormat(head []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return ".zip", nil
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return ".xml.gz", nil
+	}
+	// xml, possibly preceded by a BOM and whitespace
+	head = bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))
+	head = bytes.TrimLeft(head, " \t\r\n")
+	if bytes.HasPrefix(head, []byte("<")) {
+		return ".xml", nil
+	}
+	return "", ErrUpdaterWrongDataFormat
+}
+
+// fsError maps a filesystem error to the corresponding wurfl sentinel, so that errors.Is
+// checks work the same way for files on disk and files in memory
+func fsError(err error) error {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("%w: %v", ErrFileNotFound, err)
+	case errors.Is(err, fs.ErrPermission):
+		return fmt.Errorf("%w: %v", ErrPermissionDenied, err)
+	}
+	return fmt.Errorf("%w: %v", ErrInputOutputFailure, err)
+}
+
+// newStagingDir creates the private directory used to stage in-memory data files
+func newStagingDir() (string, error) {
+	dir, err := os.MkdirTemp("", "wurfl-")
+	if err != nil {
+		return "", fsError(err)
+	}
+	return dir, nil
+}
+
+// stageData copies r into a new file in dir, named base plus the extension of the data format
+func stageData(dir string, base string, r io.Reader) (string, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF {
+		return "", fsError(err)
+	}
+	if len(head) == 0 {
+		return "", ErrUnexpectedEndOfFile
+	}
+	ext, err := detectDataFormat(head)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, base+ext)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", fsError(err)
+	}
+	if _, err := io.Copy(f, br); err != nil {
+		f.Close()
+		return "", fsError(err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fsError(err)
+	}
+	return path, nil
+}
+
+// stagePatches stages in-memory patches into o.stagingDir, creating it if needed,
+// and returns the paths of all patches in the order they were given. Errors are reported
+// as an *OptionError naming the option that added the patch.
+func (o *options) stagePatches() ([]string, error) {
+	paths := make([]string, 0, len(o.patches))
+	for i, p := range o.patches {
+		if p.open == nil {
+			paths = append(paths, p.path)
+			continue
+		}
+		if o.stagingDir == "" {
+			dir, err := newStagingDir()
+			if err != nil {
+				return nil, &OptionError{Option: p.option, Err: err}
+			}
+			o.stagingDir = dir
+		}
+		rc, err := p.open()
+		if err != nil {
+			return nil, &OptionError{Option: p.option, Err: fsError(err)}
+		}
+		path, err := stageData(o.stagingDir, "patch"+strconv.Itoa(i), rc)
+		rc.Close()
+		if err != nil {
+			return nil, &OptionError{Option: p.option, Err: err}
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// WithPatchBytes adds a patch file held in memory (xml, zip or gz)
+func WithPatchBytes(data []byte) Option {
+	return func(o *options) error {
+		if len(data) == 0 {
+			return &OptionError{Option: "WithPatchBytes", Err: ErrInvalidParameter}
+		}
+		if _, err := detectDataFormat(data); err != nil {
+			return &OptionError{Option: "WithPatchBytes", Err: err}
+		}
+		o.patches = append(o.patches, patchSource{
+			path: memoryPatch(len(o.patches)),
+			open: func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(data)), nil
+			},
+			option: "WithPatchBytes",
+		})
+		return nil
+	}
+}
+
+// WithPatchFS adds patch files read from fsys, ie: an embed.FS
+func WithPatchFS(fsys fs.FS, names ...string) Option {
+	return func(o *options) error {
+		for _, name := range names {
+			if _, err := fs.Stat(fsys, name); err != nil {
+				return &OptionError{Option: "WithPatchFS", Err: fsError(err)}
+			}
+			name := name
+			o.patches = append(o.patches, patchSource{
+				path: name,
+				open: func() (io.ReadCloser, error) {
+					return fsys.Open(name)
+				},
+				option: "WithPatchFS",
+			})
+		}
+		return nil
+	}
+}
+
+// CreateFromBytes creates the wurfl engine from a WURFL data file held in memory.
+// data can be in zip, gz or xml format; other formats fail with ErrUpdaterWrongDataFormat.
+// It is staged to a temporary file, see the comment at the top of datasource.go.
+func CreateFromBytes(data []byte, opts ...Option) (*Wurfl, error) {
+	o, err := applyOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, ErrUnexpectedEndOfFile
+	}
+	if _, err := detectDataFormat(data[:min(len(data), 512)]); err != nil {
+		return nil, err
+	}
+
+	dir, err := newStagingDir()
+	if err != nil {
+		return nil, err
+	}
+	path, err := stageData(dir, "wurfl", bytes.NewReader(data))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	o.stagingDir = dir
+	return create(path, o)
+}
+
+// CreateFromReader creates the wurfl engine reading the WURFL data file (zip, gz or xml) from r
+func CreateFromReader(r io.Reader, opts ...Option) (*Wurfl, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fsError(err)
+	}
+	return CreateFromBytes(data, opts...)
+}
+
+// CreateFromFS creates the wurfl engine from the WURFL data file name in fsys, ie: an embed.FS
+//
+//	//go:embed wurfl.zip
+//	var data embed.FS
+//	wengine, err := wurfl.CreateFromFS(data, "wurfl.zip", wurfl.WithCacheSize(100000))
+func CreateFromFS(fsys fs.FS, name string, opts ...Option) (*Wurfl, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, fsError(err)
+	}
+	return CreateFromBytes(data, opts...)
+}
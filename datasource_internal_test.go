@@ -0,0 +1,84 @@
+package wurfl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testWurflZip returns the path of the WURFL data file used by the internal tests
+func testWurflZip() string {
+	if _, err := os.Stat("/usr/local/share/wurfl/wurfl.zip"); err == nil {
+		// macosx rootless
+		return "/usr/local/share/wurfl/wurfl.zip"
+	}
+	return "/usr/share/wurfl/wurfl.zip"
+}
+
+const testMemoryPatch = `<?xml version="1.0" encoding="UTF-8"?>
+<wurfl_patch>
+	<devices>
+		<device id="golang_wurfl_test_device" user_agent="GolangWurflTestAgent/1.0" fall_back="generic">
+			<group id="product_info">
+				<capability name="brand_name" value="GolangWurfl"/>
+			</group>
+		</device>
+	</devices>
+</wurfl_patch>
+`
+
+func TestCreateFromBytes_Staged(t *testing.T) {
+	data, err := os.ReadFile(testWurflZip())
+	require.NoError(t, err)
+	wengine, err := CreateFromBytes(data, WithPatchBytes([]byte(testMemoryPatch)), WithDataFileValidation())
+	require.NoError(t, err)
+
+	e := wengine.engine.Load()
+	dir := e.stagingDir
+	assert.NotEmpty(t, dir)
+	assert.Equal(t, dir, filepath.Dir(e.root))
+	device, err := wengine.LookupDeviceID("golang_wurfl_test_device")
+	require.NoError(t, err)
+	device.Destroy()
+
+	wengine.Destroy()
+	_, err = os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCreateFromBytes_Patches(t *testing.T) {
+	data, err := os.ReadFile(testWurflZip())
+	require.NoError(t, err)
+	patch := filepath.Join(t.TempDir(), "patch.xml")
+	require.NoError(t, os.WriteFile(patch, []byte(testMemoryPatch), 0o600))
+
+	// patch files are added to the data file held in memory
+	wengine, err := CreateFromBytes(data, WithPatches(patch),
+		WithPatchBytes([]byte(testMemoryPatch)), WithPatchBytes([]byte(testMemoryPatch)))
+	require.NoError(t, err)
+	defer wengine.Destroy()
+
+	device, err := wengine.LookupDeviceID("golang_wurfl_test_device")
+	require.NoError(t, err)
+	brand, err := device.GetStaticCap("brand_name")
+	assert.NoError(t, err)
+	assert.Equal(t, "GolangWurfl", brand)
+	device.Destroy()
+
+	// the in-memory patches can be told apart
+	c, err := wengine.Config()
+	require.NoError(t, err)
+	assert.Equal(t, []string{patch, memoryPatch(1), memoryPatch(2)}, c.Patches)
+}
+
+func TestCreateFromBytes_StagingError(t *testing.T) {
+	data, err := os.ReadFile(testWurflZip())
+	require.NoError(t, err)
+
+	t.Setenv("TMPDIR", "/nonexistent/wurfl-tmp")
+	_, err = CreateFromBytes(data)
+	assert.ErrorIs(t, err, ErrFileNotFound)
+}
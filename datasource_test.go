@@ -0,0 +1,87 @@
+package wurfl_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	wurfl "github.com/WURFL/golang-wurfl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPatch adds a new device to the WURFL data file
+const testPatch = `<?xml version="1.0" encoding="UTF-8"?>
+<wurfl_patch>
+	<devices>
+		<device id="golang_wurfl_test_device" user_agent="GolangWurflTestAgent/1.0" fall_back="generic">
+			<group id="product_info">
+				<capability name="brand_name" value="GolangWurfl"/>
+			</group>
+		</device>
+	</devices>
+</wurfl_patch>
+`
+
+func TestCreateFromBytes(t *testing.T) {
+	data, err := os.ReadFile(fixtureWurflZip())
+	require.NoError(t, err)
+
+	wengine, err := wurfl.CreateFromBytes(data, wurfl.WithCacheSize(100000), wurfl.WithPatchBytes([]byte(testPatch)))
+	require.NoError(t, err)
+	defer wengine.Destroy()
+
+	device, err := wengine.LookupDeviceID("golang_wurfl_test_device")
+	require.NoError(t, err)
+	defer device.Destroy()
+
+	brand, err := device.GetStaticCap("brand_name")
+	assert.NoError(t, err)
+	assert.Equal(t, "GolangWurfl", brand)
+}
+
+func TestCreateFromFS(t *testing.T) {
+	dir := filepath.Dir(fixtureWurflZip())
+	fsys := os.DirFS(dir)
+	patches := fstest.MapFS{"patch.xml": &fstest.MapFile{Data: []byte(testPatch)}}
+
+	wengine, err := wurfl.CreateFromFS(fsys, "wurfl.zip", wurfl.WithPatchFS(patches, "patch.xml"))
+	require.NoError(t, err)
+	defer wengine.Destroy()
+
+	device, err := wengine.LookupUserAgent("ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	require.NoError(t, err)
+	defer device.Destroy()
+
+	deviceid, err := device.GetDeviceID()
+	assert.NoError(t, err)
+	assert.Equal(t, "apple_iphone_ver8_3_subuacfnetwork", deviceid)
+
+	_, err = wurfl.CreateFromFS(fsys, "missing.zip")
+	assert.ErrorIs(t, err, wurfl.ErrFileNotFound)
+}
+
+func TestCreateFromReader_WrongFormat(t *testing.T) {
+	_, err := wurfl.CreateFromReader(bytes.NewReader([]byte("this is not a wurfl data file")))
+	assert.ErrorIs(t, err, wurfl.ErrUpdaterWrongDataFormat)
+
+	_, err = wurfl.CreateFromBytes(nil)
+	assert.ErrorIs(t, err, wurfl.ErrUnexpectedEndOfFile)
+}
+
+func TestWithPatchBytes_Invalid(t *testing.T) {
+	var optErr *wurfl.OptionError
+
+	_, err := wurfl.CreateFromBytes([]byte("<wurfl/>"), wurfl.WithPatchBytes([]byte("not a patch")))
+	assert.ErrorIs(t, err, wurfl.ErrUpdaterWrongDataFormat)
+	require.True(t, errors.As(err, &optErr))
+	assert.Equal(t, "WithPatchBytes", optErr.Option)
+
+	_, err = wurfl.CreateFromBytes([]byte("<wurfl/>"), wurfl.WithPatchFS(fstest.MapFS{}, "missing.xml"))
+	assert.ErrorIs(t, err, wurfl.ErrFileNotFound)
+	require.True(t, errors.As(err, &optErr))
+	assert.Equal(t, "WithPatchFS", optErr.Option)
+}
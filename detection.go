@@ -0,0 +1,166 @@
+package wurfl
+
+//
+//#include <wurfl/wurfl.h>
+import "C"
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime"
+)
+
+// Detection is the result of a lookup read once from its Device, which is destroyed before
+// the Detect lookups return. It is a plain value: it needs no Destroy, stays valid after the
+// engine is destroyed or reloaded, can be marshaled to JSON, kept in a cache and shared
+// between goroutines, as long as it is not modified.
+type Detection struct {
+	DeviceID          string `json:"device_id"`
+	RootID            string `json:"root_id"`
+	ParentID          string `json:"parent_id"`
+	MatchType         int    `json:"match_type"` // one of the WurflMatchType constants
+	OriginalUserAgent string `json:"original_user_agent"`
+	// Capabilities and VirtualCapabilities hold the capabilities set with WithDetectionCaps
+	Capabilities        map[string]string `json:"capabilities,omitempty"`
+	VirtualCapabilities map[string]string `json:"virtual_capabilities,omitempty"`
+	// Fallback is set for the fallback device of a context lookup, see WithFallbackDevice
+	Fallback bool `json:"fallback,omitempty"`
+}
+
+// WithDetectionCaps sets the capabilities, static or virtual, read into every Detection.
+// They are checked when the engine is loaded: a name that is not a capability of the
+// engine, or that the capability filter leaves out, fails it with ErrCapabilityNotFound.
+func WithDetectionCaps(caps ...string) Option {
+	return func(o *options) error {
+		for _, c := range caps {
+			if c == "" {
+				return &OptionError{Option: "WithDetectionCaps", Err: ErrInvalidParameter}
+			}
+		}
+		o.detectionCaps = append(o.detectionCaps, caps...)
+		return nil
+	}
+}
+
+// detectionCaps are the capabilities of the Detections of an engine, see WithDetectionCaps
+type detectionCaps struct {
+	static  []string
+	virtual []string
+}
+
+// loadDetectionCaps sorts caps into the static and virtual capabilities of e; it needs the
+// capability C string cache
+func (e *engine) loadDetectionCaps(caps []string) error {
+	for _, cap := range caps {
+		if _, found := e.capsCStringcache[cap]; !found {
+			return &OptionError{Option: "WithDetectionCaps", Err: fmt.Errorf("%w: %q", ErrCapabilityNotFound, cap)}
+		}
+		if e.virtualCaps[cap] {
+			e.detection.virtual = append(e.detection.virtual, cap)
+		} else {
+			e.detection.static = append(e.detection.static, cap)
+		}
+	}
+	return nil
+}
+
+// Detection reads the ids, match type, original user agent and the capabilities set with
+// WithDetectionCaps of d. The Detection stays valid once d is destroyed.
+func (d *Device) Detection() (*Detection, error) {
+	e, err := d.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer e.release()
+	defer runtime.KeepAlive(d)
+
+	cdeviceid := C.wurfl_device_get_id(d.Device)
+	if cdeviceid == nil {
+		return nil, checkHandleError(d.Wurfl)
+	}
+	det := &Detection{
+		DeviceID:  C.GoString(cdeviceid),
+		RootID:    C.GoString(C.wurfl_device_get_root_id(d.Device)),
+		ParentID:  C.GoString(C.wurfl_device_get_parent_id(d.Device)),
+		MatchType: int(C.wurfl_device_get_match_type(d.Device)),
+		Fallback:  d.fallback,
+	}
+	// a device looked up by id has no original user agent
+	if oua := C.wurfl_device_get_original_useragent(d.Device); oua != nil {
+		det.OriginalUserAgent = C.GoString(oua)
+	}
+
+	if len(e.detection.static) != 0 {
+		det.Capabilities = make(map[string]string, len(e.detection.static))
+	}
+	for _, cap := range e.detection.static {
+		if d.usage != nil {
+			d.usage.recordStatic(cap, e.mandatoryCaps[cap])
+		}
+		cErr := C.wurfl_error(0)
+		ccapvalue := C.wurfl_device_get_static_cap(d.Device, d.capsCStringcache[cap], &cErr)
+		if cErr != C.WURFL_OK {
+			return nil, cErrorToGoError(cErr)
+		}
+		det.Capabilities[cap] = C.GoString(ccapvalue)
+	}
+
+	if len(e.detection.virtual) != 0 {
+		det.VirtualCapabilities = make(map[string]string, len(e.detection.virtual))
+	}
+	for _, vcap := range e.detection.virtual {
+		if d.usage != nil {
+			d.usage.recordVirtual(vcap)
+		}
+		cErr := C.wurfl_error(0)
+		ccapvalue := C.wurfl_device_get_virtual_cap(d.Device, d.capsCStringcache[vcap], &cErr)
+		if cErr != C.WURFL_OK {
+			return nil, cErrorToGoError(cErr)
+		}
+		det.VirtualCapabilities[vcap] = C.GoString(ccapvalue)
+	}
+	return det, nil
+}
+
+// detect returns the Detection of the device returned by a lookup, and destroys it
+func detect(device *Device, err error) (*Detection, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer device.Destroy()
+	return device.Detection()
+}
+
+// DetectUserAgent is like LookupUserAgent, returning a Detection instead of a Device
+func (w *Wurfl) DetectUserAgent(ua string) (*Detection, error) {
+	return detect(w.LookupUserAgent(ua))
+}
+
+// DetectRequest is like LookupRequest, returning a Detection instead of a Device
+func (w *Wurfl) DetectRequest(r *http.Request) (*Detection, error) {
+	return detect(w.LookupRequest(r))
+}
+
+// DetectWithImportantHeaderMap is like LookupWithImportantHeaderMap, returning a Detection
+// instead of a Device
+func (w *Wurfl) DetectWithImportantHeaderMap(IHMap map[string]string) (*Detection, error) {
+	return detect(w.LookupWithImportantHeaderMap(IHMap))
+}
+
+// DetectDeviceID is like LookupDeviceID, returning a Detection instead of a Device
+func (w *Wurfl) DetectDeviceID(DeviceID string) (*Detection, error) {
+	return detect(w.LookupDeviceID(DeviceID))
+}
+
+// DetectRequestContext is like LookupRequestContext, returning a Detection instead of a
+// Device; the Detection of the fallback device has its Fallback field set.
+func (w *Wurfl) DetectRequestContext(ctx context.Context, r *http.Request) (*Detection, error) {
+	return detect(w.LookupRequestContext(ctx, r))
+}
+
+// DetectUserAgentContext is like LookupUserAgentContext, returning a Detection instead of a
+// Device; the Detection of the fallback device has its Fallback field set.
+func (w *Wurfl) DetectUserAgentContext(ctx context.Context, ua string) (*Detection, error) {
+	return detect(w.LookupUserAgentContext(ctx, ua))
+}
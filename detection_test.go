@@ -0,0 +1,184 @@
+package wurfl_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+
+	wurfl "github.com/WURFL/golang-wurfl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWurfl_DetectUserAgent(t *testing.T) {
+	wengine, err := wurfl.CreateWithOptions(fixtureWurflZip(),
+		wurfl.WithDetectionCaps("brand_name", "model_name", "form_factor", "complete_device_name"))
+	require.NoError(t, err)
+
+	ua := "ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0"
+	device, err := wengine.LookupUserAgent(ua)
+	require.NoError(t, err)
+	id, err := device.GetDeviceID()
+	require.NoError(t, err)
+	brand, err := device.GetStaticCap("brand_name")
+	require.NoError(t, err)
+	formFactor, err := device.GetVirtualCap("form_factor")
+	require.NoError(t, err)
+	want, err := device.Detection()
+	require.NoError(t, err)
+	assert.Equal(t, id, want.DeviceID)
+	assert.Equal(t, device.GetRootID(), want.RootID)
+	assert.Equal(t, device.GetParentID(), want.ParentID)
+	assert.Equal(t, device.GetMatchType(), want.MatchType)
+	assert.Equal(t, ua, want.OriginalUserAgent)
+	assert.Equal(t, brand, want.Capabilities["brand_name"])
+	assert.Len(t, want.Capabilities, 2)
+	assert.Equal(t, formFactor, want.VirtualCapabilities["form_factor"])
+	assert.Len(t, want.VirtualCapabilities, 2)
+	assert.False(t, want.Fallback)
+	device.Destroy()
+
+	// the Detect lookups leave no Device behind
+	before := wurfl.Handles()
+	det, err := wengine.DetectUserAgent(ua)
+	require.NoError(t, err)
+	assert.Equal(t, want, det)
+	assert.Equal(t, before.LiveDevices, wurfl.Handles().LiveDevices)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	req.Header.Set("User-Agent", ua)
+	det, err = wengine.DetectRequest(req)
+	require.NoError(t, err)
+	assert.Equal(t, want, det)
+
+	det, err = wengine.DetectWithImportantHeaderMap(map[string]string{"User-Agent": ua})
+	require.NoError(t, err)
+	assert.Equal(t, want, det)
+
+	det, err = wengine.DetectRequestContext(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, want, det)
+
+	det, err = wengine.DetectDeviceID(id)
+	require.NoError(t, err)
+	assert.Equal(t, want.DeviceID, det.DeviceID)
+	assert.Equal(t, want.Capabilities, det.Capabilities)
+
+	_, err = wengine.DetectDeviceID("not_a_device_id")
+	assert.Error(t, err)
+	assert.Equal(t, before.LiveDevices, wurfl.Handles().LiveDevices)
+
+	// a Detection outlives its engine and goes through JSON unchanged
+	wengine.Destroy()
+	data, err := json.Marshal(want)
+	require.NoError(t, err)
+	var decoded wurfl.Detection
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, *want, decoded)
+	assert.Equal(t, id, want.DeviceID)
+}
+
+func TestWurfl_Detect_NoCaps(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+	defer wengine.Destroy()
+
+	det, err := wengine.DetectUserAgent("ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	require.NoError(t, err)
+	assert.Equal(t, "apple_iphone_ver8_3_subuacfnetwork", det.DeviceID)
+	assert.Nil(t, det.Capabilities)
+	assert.Nil(t, det.VirtualCapabilities)
+
+	data, err := json.Marshal(det)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "capabilities")
+}
+
+func TestWurfl_Detect_Concurrent(t *testing.T) {
+	wengine, err := wurfl.CreateWithOptions(fixtureWurflZip(), wurfl.WithDetectionCaps("brand_name", "form_factor"))
+	require.NoError(t, err)
+	defer wengine.Destroy()
+
+	// Detections are shared between goroutines through a cache
+	var cache sync.Map
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				ua := "ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0"
+				if _, found := cache.Load(ua); !found {
+					det, err := wengine.DetectUserAgent(ua)
+					if !assert.NoError(t, err) {
+						return
+					}
+					cache.Store(ua, det)
+				}
+				det, _ := cache.Load(ua)
+				assert.Equal(t, "apple_iphone_ver8_3_subuacfnetwork", det.(*wurfl.Detection).DeviceID)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestWurfl_DetectUserAgentContext_Fallback(t *testing.T) {
+	wengine, err := wurfl.CreateWithOptions(fixtureWurflZip(),
+		wurfl.WithFallbackDevice("generic"),
+		wurfl.WithDetectionCaps("brand_name"))
+	require.NoError(t, err)
+	defer wengine.Destroy()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	det, err := wengine.DetectUserAgentContext(ctx, "ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	require.NoError(t, err)
+	assert.True(t, det.Fallback)
+	assert.Equal(t, "generic", det.DeviceID)
+	assert.Contains(t, det.Capabilities, "brand_name")
+}
+
+func TestWithDetectionCaps_Invalid(t *testing.T) {
+	_, err := wurfl.CreateWithOptions(fixtureWurflZip(), wurfl.WithDetectionCaps(""))
+	var optErr *wurfl.OptionError
+	require.ErrorAs(t, err, &optErr)
+	assert.Equal(t, "WithDetectionCaps", optErr.Option)
+	assert.ErrorIs(t, err, wurfl.ErrInvalidParameter)
+
+	before := wurfl.Handles()
+	_, err = wurfl.CreateWithOptions(fixtureWurflZip(), wurfl.WithDetectionCaps("brand_name", "no_such_capability"))
+	require.ErrorAs(t, err, &optErr)
+	assert.Equal(t, "WithDetectionCaps", optErr.Option)
+	assert.ErrorIs(t, err, wurfl.ErrCapabilityNotFound)
+	assert.ErrorContains(t, err, "no_such_capability")
+	assert.Equal(t, before.LiveEngines, wurfl.Handles().LiveEngines)
+}
+
+func TestConfig_DetectionCaps(t *testing.T) {
+	t.Setenv("WURFL_DATA_FILE", fixtureWurflZip())
+	t.Setenv("WURFL_DETECTION_CAPS", "brand_name, form_factor")
+
+	c, err := wurfl.LoadConfig("")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"brand_name", "form_factor"}, c.DetectionCaps)
+
+	wengine, err := wurfl.CreateFromConfig(c)
+	require.NoError(t, err)
+	defer wengine.Destroy()
+
+	det, err := wengine.DetectUserAgent("ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	require.NoError(t, err)
+	assert.Contains(t, det.Capabilities, "brand_name")
+	assert.Contains(t, det.VirtualCapabilities, "form_factor")
+
+	// the capabilities survive a Reload and show in the effective configuration
+	require.NoError(t, wengine.Reload(fixtureWurflZip(), nil))
+	det, err = wengine.DetectUserAgent("ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	require.NoError(t, err)
+	assert.Contains(t, det.VirtualCapabilities, "form_factor")
+	effective, err := wengine.Config()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"brand_name", "form_factor"}, effective.DetectionCaps)
+}
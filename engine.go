@@ -0,0 +1,586 @@
+package wurfl
+
+//
+//#include <wurfl/wurfl.h>
+import "C"
+
+import (
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// engine is a loaded libwurfl handle together with the Go-side caches built on it.
+// A Wurfl points to its current engine; Reload builds a new engine and swaps it in,
+// while the old one is kept alive until the last lookup or Device using it is done.
+//
+// Reference counting: the Wurfl holds one reference on its current engine, every
+// method holds one for the duration of the call and every Device holds one until
+// Destroy. The engine is freed when the count drops to zero, or by close (Wurfl.Destroy),
+// which waits for the calls in progress and makes the later ones fail with ErrEngineClosed.
+type engine struct {
+	handle           C.wurfl_handle
+	root             string
+	headers          atomic.Pointer[headerSet] // current important headers, see loadImportantHeaders
+	oldHeaders       []*headerSet              // replaced by SetAttr, lookups may still use them until free
+	capsCStringcache map[string]*C.char
+	virtualCaps      map[string]bool // virtual capabilities that are not static ones, see batch.go
+	mandatoryCaps    map[string]bool // capabilities loaded whatever the filter, see usage.go
+	stagingDir       string
+	usage            atomic.Pointer[CapabilityUsageRecorder] // nil unless recording, see usage.go
+	leaks            leakMode                                // leak detection of the Devices, see leak.go
+	fallbackDeviceID string                                  // returned by the context lookups, see context.go
+	detection        detectionCaps                           // capabilities of the Detections, see detection.go
+
+	refs     atomic.Int64
+	devices  atomic.Int64 // Devices holding a reference, the other references are the owner and the calls
+	calls    atomic.Int64 // calls in progress, see tryAcquire
+	closed   atomic.Bool  // set by close
+	idleOnce sync.Once
+	idle     chan struct{} // closed by the release ending the last call of a closed engine
+	freeOnce sync.Once
+	freed    chan struct{} // closed by free
+
+	deviceMu      sync.Mutex
+	deviceHandles map[C.wurfl_device_handle]struct{} // handles of the live Devices, destroyed by close
+}
+
+// newEngine creates and loads a libwurfl handle for root, applying o around wurfl_load.
+// The updater is configured but not started.
+func newEngine(root string, o *options) (*engine, error) {
+	e := &engine{root: root, leaks: o.leaks, idle: make(chan struct{}), freed: make(chan struct{}),
+		deviceHandles: make(map[C.wurfl_device_handle]struct{})}
+	e.refs.Store(1)
+
+	e.handle = C.wurfl_create()
+
+	if e.handle == nil {
+		// error in create : no way to get the error as the is no engine instance yet
+		// in libwurfl. We can only return a generic memory allocation error
+		if o.stagingDir != "" {
+			os.RemoveAll(o.stagingDir)
+		}
+		return nil, cErrorToGoError(C.WURFL_ERROR_UNABLE_TO_ALLOCATE_MEMORY)
+	}
+
+	// from now on the staging directory, if any, is removed by free
+	e.stagingDir = o.stagingDir
+
+	// setting cache if specified
+	if o.cacheProvider != WurflCacheProviderDefault {
+		ccacheec := cString(o.cacheExtraConfig)
+
+		cp := C.wurfl_cache_provider(o.cacheProvider)
+		C.wurfl_set_cache_provider(e.handle, cp, ccacheec)
+		cFree(ccacheec)
+	}
+
+	// setting log path before load, so that loading is logged too
+	if o.logPath != "" {
+		if err := e.setLogPath(o.logPath); err != nil {
+			e.free()
+			return nil, &OptionError{Option: "WithLogPath", Err: err}
+		}
+	}
+
+	// checking files before the long libwurfl load, see validate.go
+	if o.validateFiles {
+		if err := ValidateDataFile(root); err != nil {
+			e.free()
+			return nil, err
+		}
+	}
+
+	// setting wurfl.xml
+	wxml := cString(root)
+	defer cFree(wxml)
+	if ret := C.wurfl_set_root(e.handle, wxml); ret != C.WURFL_OK {
+		e.free()
+		return nil, cErrorToGoError(ret)
+	}
+
+	// setting patches, staging in-memory ones first
+	patches, err := o.stagePatches()
+	e.stagingDir = o.stagingDir
+	if err != nil {
+		e.free()
+		return nil, err
+	}
+	for i := 0; i < len(patches); i++ {
+		if o.validateFiles {
+			if err := ValidatePatchFile(patches[i]); err != nil {
+				e.free()
+				return nil, err
+			}
+		}
+		cpatch := cString(patches[i])
+		if ret := C.wurfl_add_patch(e.handle, cpatch); ret != C.WURFL_OK {
+			cFree(cpatch)
+			e.free()
+			return nil, cErrorToGoError(ret)
+		}
+		cFree(cpatch)
+	}
+
+	// filter capabilities in engine
+	for i := 0; i < len(o.capFilter); i++ {
+		ccap := cString(o.capFilter[i])
+		if ret := C.wurfl_add_requested_capability(e.handle, ccap); ret != C.WURFL_OK {
+			cFree(ccap)
+			e.free()
+			return nil, cErrorToGoError(ret)
+		}
+		cFree(ccap)
+	}
+
+	// loading engine
+	if ret := C.wurfl_load(e.handle); ret != C.WURFL_OK {
+		// we prefer wurfl handle based error message as it is richer than the standard one
+		err := checkHandleError(e.handle)
+		if err == nil {
+			err = cErrorToGoError(ret)
+		}
+		e.free()
+		return nil, err
+	}
+
+	// setting attributes: they need a loaded engine
+	for _, a := range o.attrs {
+		cattr := C.wurfl_attr(a.attr)
+		if C.wurfl_set_attr(e.handle, cattr, C.int(a.value)) != C.WURFL_OK {
+			err := checkHandleError(e.handle)
+			e.free()
+			return nil, &OptionError{Option: "WithAttr", Err: err}
+		}
+	}
+
+	if err := e.loadImportantHeaders(); err != nil {
+		e.free()
+		return nil, err
+	}
+
+	// initialize caps/vcaps CString cache for faster calls to libwurfl
+
+	caps := e.enumNames(WurflEnumStaticCapabilities)
+	vcaps := e.enumNames(WurflEnumVirtualCapabilities)
+
+	e.capsCStringcache = make(map[string]*C.char, len(caps)+len(vcaps))
+
+	for c := range caps {
+		e.capsCStringcache[caps[c]] = cString(caps[c])
+	}
+
+	e.virtualCaps = make(map[string]bool, len(vcaps))
+	for v := range vcaps {
+		if _, found := e.capsCStringcache[vcaps[v]]; !found {
+			e.capsCStringcache[vcaps[v]] = cString(vcaps[v])
+			e.virtualCaps[vcaps[v]] = true
+		}
+	}
+
+	e.mandatoryCaps = stringSet(e.enumNames(WurflEnumMandatoryCapabilities))
+
+	if err := e.loadDetectionCaps(o.detectionCaps); err != nil {
+		e.free()
+		return nil, err
+	}
+
+	// canary corpus, verified before recording the capability usage
+	if o.corpus != nil {
+		if err := e.verify(o.corpus); err != nil {
+			e.free()
+			return nil, err
+		}
+	}
+	e.usage.Store(o.usage)
+
+	if o.fallbackDeviceID != "" {
+		if err := e.checkFallbackDevice(o.fallbackDeviceID); err != nil {
+			e.free()
+			return nil, err
+		}
+		e.fallbackDeviceID = o.fallbackDeviceID
+	}
+
+	// updater settings
+	if err := e.applyUpdaterOptions(o); err != nil {
+		e.free()
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// headerSet is an immutable snapshot of the important headers of an engine: a lookup loads
+// it once and uses it for the whole call, while loadImportantHeaders builds a new one and
+// swaps it in. A replaced set is kept until the engine is freed, as concurrent lookups may
+// still be using its C strings.
+type headerSet struct {
+	names  []string
+	cnames []*C.char       // C copies of names, in the same order
+	index  map[*C.char]int // position of each of cnames
+	list   []bool          // whether each of names is a list-valued header, see isListHeader
+	trie   headerTrie
+}
+
+// Names returns a copy of the header names
+func (hs *headerSet) Names() []string {
+	return append([]string(nil), hs.names...)
+}
+
+func (hs *headerSet) free() {
+	for _, cname := range hs.cnames {
+		cFree(cname)
+	}
+}
+
+// loadImportantHeaders builds the important header names, their C strings and the trie,
+// and swaps them in. The caller must serialize the calls (newEngine, or setAttr under Wurfl.mu).
+func (e *engine) loadImportantHeaders() error {
+	ihe := C.wurfl_get_important_header_enumerator(e.handle)
+	if ihe == nil { // Check if enumerator creation failed
+		return checkHandleError(e.handle)
+	}
+	defer C.wurfl_important_header_enumerator_destroy(ihe)
+
+	hs := &headerSet{}
+	for C.wurfl_important_header_enumerator_is_valid(ihe) != 0 {
+		// get the header name
+		headerName := C.wurfl_important_header_enumerator_get_value(ihe)
+		// convert header name to go string
+		gheaderName := C.GoString(headerName)
+		// create a C string copy from the go string
+		cheaderName := cString(gheaderName) // This CString needs to be managed (freed in free)
+		// append to slice
+		hs.names = append(hs.names, gheaderName)
+		hs.cnames = append(hs.cnames, cheaderName)
+		// advance
+		C.wurfl_important_header_enumerator_move_next(ihe)
+	}
+
+	// build a trie-based cache for important header names, for case-insensitive lookup without allocation
+	hs.index = make(map[*C.char]int, len(hs.cnames))
+	for i, name := range hs.names {
+		hs.trie.set(name, hs.cnames[i])
+		hs.index[hs.cnames[i]] = i
+		hs.list = append(hs.list, isListHeader(name))
+	}
+
+	// reuse a set with the same names, so that toggling an attribute does not grow oldHeaders
+	cur := e.headers.Load()
+	for _, known := range append([]*headerSet{cur}, e.oldHeaders...) {
+		if known != nil && slices.Equal(known.names, hs.names) {
+			hs.free()
+			hs = known
+			break
+		}
+	}
+	if hs == cur {
+		return nil
+	}
+
+	e.headers.Store(hs)
+	if cur != nil {
+		e.oldHeaders = append(e.oldHeaders, cur)
+	}
+	// hs is current now, it may come from oldHeaders
+	e.oldHeaders = slices.DeleteFunc(e.oldHeaders, func(old *headerSet) bool { return old == hs })
+	return nil
+}
+
+// enumNames returns all the names of the enumerator type et
+func (e *engine) enumNames(et C.wurfl_enum_type) []string {
+	var result []string
+
+	eh := C.wurfl_enum_create(e.handle, et)
+	defer C.wurfl_enum_destroy(eh)
+
+	for C.wurfl_enum_is_valid(eh) != 0 {
+		cname := C.wurfl_enum_get_name(eh)
+		result = append(result, C.GoString(cname))
+		C.wurfl_enum_move_next(eh)
+	}
+
+	return result
+}
+
+// tryAcquire takes a reference on e for the duration of a call, failing if e has already
+// been released for good or closed by Destroy. The call ends with release.
+func (e *engine) tryAcquire() bool {
+	for {
+		n := e.refs.Load()
+		if n <= 0 {
+			return false
+		}
+		if e.refs.CompareAndSwap(n, n+1) {
+			break
+		}
+	}
+	// close sets closed before waiting for calls to drop to zero: either close sees this
+	// call, or this call sees closed
+	e.calls.Add(1)
+	if e.closed.Load() {
+		e.release()
+		return false
+	}
+	return true
+}
+
+// release ends a call started with tryAcquire, waking up close if it is the last one
+func (e *engine) release() {
+	if e.calls.Add(-1) == 0 && e.closed.Load() {
+		e.idleOnce.Do(func() { close(e.idle) })
+	}
+	e.unref()
+}
+
+// retain takes an additional reference on e, held by a Device until unref; the caller
+// must already hold one
+func (e *engine) retain() *engine {
+	e.refs.Add(1)
+	return e
+}
+
+// unref drops a reference on e, freeing it when it was the last one
+func (e *engine) unref() {
+	if e.refs.Add(-1) == 0 {
+		e.free()
+	}
+}
+
+// close frees e even if Devices still hold references on it: new calls fail with
+// ErrEngineClosed, and the calls in progress are waited for first.
+func (e *engine) close() {
+	e.closed.Store(true)
+	// a call in progress now sees closed when it releases e, and the calls starting from
+	// now on fail: the last release closes idle
+	if e.calls.Load() != 0 {
+		<-e.idle
+	}
+	e.destroyDevices()
+	e.free()
+}
+
+// addDeviceHandle registers the handle of a Device looked up on e
+func (e *engine) addDeviceHandle(h C.wurfl_device_handle) {
+	e.deviceMu.Lock()
+	e.deviceHandles[h] = struct{}{}
+	e.deviceMu.Unlock()
+}
+
+// removeDeviceHandle unregisters the handle of a Device destroyed before e is closed
+func (e *engine) removeDeviceHandle(h C.wurfl_device_handle) {
+	e.deviceMu.Lock()
+	delete(e.deviceHandles, h)
+	e.deviceMu.Unlock()
+}
+
+// destroyDevices destroys the handles of the Devices still alive when e is closed: their
+// methods fail with ErrEngineClosed from now on, and their Destroy only drops the reference.
+// It runs once no call is in progress, so no Device is using its handle.
+func (e *engine) destroyDevices() {
+	e.deviceMu.Lock()
+	defer e.deviceMu.Unlock()
+	for h := range e.deviceHandles {
+		C.wurfl_device_destroy(h)
+		delete(e.deviceHandles, h)
+	}
+}
+
+// free destroys the libwurfl handle and frees the C strings. It runs at most once,
+// either when the last reference is released or when the Wurfl is destroyed.
+func (e *engine) free() {
+	e.freeOnce.Do(func() {
+		// deallocate important headers C strings
+		if hs := e.headers.Load(); hs != nil {
+			hs.free()
+		}
+		for _, hs := range e.oldHeaders {
+			hs.free()
+		}
+		e.oldHeaders = nil
+
+		// now free the caps/vcaps CStrings cache
+		for _, v := range e.capsCStringcache {
+			if v != nil {
+				cFree(v)
+			}
+		}
+		e.capsCStringcache = nil // Clear the map
+
+		if e.handle != nil {
+			C.wurfl_destroy(e.handle)
+			e.handle = nil
+		}
+
+		// remove data files staged from memory
+		if e.stagingDir != "" {
+			os.RemoveAll(e.stagingDir)
+			e.stagingDir = ""
+		}
+
+		close(e.freed)
+	})
+}
+
+// setLogPath - set path of main libwurfl log file
+func (e *engine) setLogPath(LogFile string) error {
+	clog := cString(LogFile)
+	ret := C.wurfl_set_log_path(e.handle, clog)
+	cFree(clog)
+	if ret != C.WURFL_OK {
+		return cErrorToGoError(ret)
+	}
+	return nil
+}
+
+// setAttr validates and sets an engine attribute, rebuilding the important headers when they depend on it
+func (e *engine) setAttr(attr int, value int) error {
+	if err := Attr(attr).Validate(AttrValue(value)); err != nil {
+		return err
+	}
+	cattr := C.wurfl_attr(attr)
+	cvalue := C.int(value)
+	if C.wurfl_set_attr(e.handle, cattr, cvalue) != C.WURFL_OK {
+		return checkHandleError(e.handle)
+	}
+
+	// now reload all important header in engine since they are different
+	// if the attr is WurflAttrExtraHeadersExperimental
+	if attr == WurflAttrExtraHeadersExperimental {
+		return e.loadImportantHeaders()
+	}
+	return nil
+}
+
+// getAttr returns the value of an engine attribute
+func (e *engine) getAttr(attr int) (int, error) {
+	if attr == WurflAttrCapabilityFallbackCache {
+		if err := requireFeature(FeatureCapabilityFallbackCache); err != nil {
+			return 0, err
+		}
+	}
+
+	cattr := C.wurfl_attr(attr)
+	var cvalue C.int
+	if C.wurfl_get_attr(e.handle, cattr, &cvalue) != C.WURFL_OK {
+		return 0, checkHandleError(e.handle)
+	}
+	return int(cvalue), nil
+}
+
+// setUpdaterDataURL - set the Snapshot URL, together with the golang updater user agent
+func (e *engine) setUpdaterDataURL(DataURL string) error {
+	// we set useragent only if API version is >= 1.13.0.0 otherwise it will overwrite the libwurfl one
+	if requireFeature(FeatureUpdaterUserAgent) == nil {
+		golangUA := "infuze_golang/" + Version
+		cgolangUA := cString(golangUA)
+		cret := C.wurfl_updater_set_useragent(e.handle, cgolangUA)
+		cFree(cgolangUA)
+		if cret != C.WURFL_OK {
+			return cErrorToGoError(cret)
+		}
+	}
+
+	cdata := cString(DataURL)
+
+	ret := C.wurfl_updater_set_data_url(e.handle, cdata)
+	cFree(cdata)
+
+	if ret != C.WURFL_OK {
+		return checkHandleError(e.handle)
+	}
+	return nil
+}
+
+// setUpdaterUserAgent - set the UserAgent used in calling the WURFL Snapshot server
+func (e *engine) setUpdaterUserAgent(userAgent string) error {
+	if err := requireFeature(FeatureUpdaterUserAgent); err != nil {
+		return err
+	}
+	cdata := cString(userAgent)
+	ret := C.wurfl_updater_set_useragent(e.handle, cdata)
+	cFree(cdata)
+	if ret != C.WURFL_OK {
+		return checkHandleError(e.handle)
+	}
+	return nil
+}
+
+// setUpdaterDataFrequency - set frequency of update checks
+func (e *engine) setUpdaterDataFrequency(Frequency int) error {
+	cfreq := C.wurfl_updater_frequency(Frequency)
+	if C.wurfl_updater_set_data_frequency(e.handle, cfreq) != C.WURFL_OK {
+		return checkHandleError(e.handle)
+	}
+	return nil
+}
+
+// setUpdaterDataURLTimeout - set connection and data transfer timeouts (in millisecs)
+func (e *engine) setUpdaterDataURLTimeout(ConnectionTimeout int, DataTransferTimeout int) error {
+	cConn := C.int(ConnectionTimeout)
+	cData := C.int(DataTransferTimeout)
+	if C.wurfl_updater_set_data_url_timeouts(e.handle, cConn, cData) != C.WURFL_OK {
+		return checkHandleError(e.handle)
+	}
+	return nil
+}
+
+// setUpdaterLogPath - set path of updater log file
+func (e *engine) setUpdaterLogPath(LogFile string) error {
+	clog := cString(LogFile)
+	ret := C.wurfl_updater_set_log_path(e.handle, clog)
+	cFree(clog)
+	if ret != C.WURFL_OK {
+		return checkHandleError(e.handle)
+	}
+	return nil
+}
+
+// updaterStart - start the updater thread
+func (e *engine) updaterStart() error {
+	if C.wurfl_updater_start(e.handle) != C.WURFL_OK {
+		return checkHandleError(e.handle)
+	}
+	return nil
+}
+
+// updaterStop - stop the updater thread
+func (e *engine) updaterStop() error {
+	if C.wurfl_updater_stop(e.handle) != C.WURFL_OK {
+		return checkHandleError(e.handle)
+	}
+	return nil
+}
+
+// applyUpdaterOptions configures the updater on a loaded engine; starting it is up to the caller
+func (e *engine) applyUpdaterOptions(o *options) error {
+	if o.updaterLogPath != "" {
+		if err := e.setUpdaterLogPath(o.updaterLogPath); err != nil {
+			return &OptionError{Option: "WithUpdaterLogPath", Err: err}
+		}
+	}
+	if o.updaterDataURL != "" {
+		if err := e.setUpdaterDataURL(o.updaterDataURL); err != nil {
+			return &OptionError{Option: "WithUpdaterDataURL", Err: err}
+		}
+	}
+	// setUpdaterDataURL sets the default golang user agent, so a custom one must come after it
+	if o.updaterUserAgent != "" {
+		if err := e.setUpdaterUserAgent(o.updaterUserAgent); err != nil {
+			return &OptionError{Option: "WithUpdaterUserAgent", Err: err}
+		}
+	}
+	if o.updaterFrequencySet {
+		if err := e.setUpdaterDataFrequency(o.updaterFrequency); err != nil {
+			return &OptionError{Option: "WithUpdaterFrequency", Err: err}
+		}
+	}
+	if o.updaterTimeoutsSet {
+		if err := e.setUpdaterDataURLTimeout(o.updaterConnTimeout, o.updaterTransferTimeout); err != nil {
+			return &OptionError{Option: "WithUpdaterTimeouts", Err: err}
+		}
+	}
+	return nil
+}
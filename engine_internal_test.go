@@ -0,0 +1,64 @@
+package wurfl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngine_CloseWaitsForCalls(t *testing.T) {
+	wengine, err := CreateWithOptions(testWurflZip())
+	require.NoError(t, err)
+
+	// a call in progress
+	e := wengine.acquire()
+	require.NotNil(t, e)
+
+	destroyed := make(chan struct{})
+	go func() {
+		wengine.Destroy()
+		close(destroyed)
+	}()
+	select {
+	case <-destroyed:
+		t.Fatal("Destroy returned while a call was in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the engine is closed: a new call fails, and does not wake up Destroy
+	require.False(t, e.tryAcquire())
+	select {
+	case <-destroyed:
+		t.Fatal("Destroy returned while a call was in progress")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	e.release()
+	select {
+	case <-destroyed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Destroy did not return once the call was done")
+	}
+	<-e.freed
+}
+
+func TestEngine_CloseDestroysDeviceHandles(t *testing.T) {
+	wengine, err := CreateWithOptions(testWurflZip())
+	require.NoError(t, err)
+	e := wengine.engine.Load()
+
+	destroyed, err := wengine.LookupDeviceID("generic")
+	require.NoError(t, err)
+	alive, err := wengine.LookupUserAgent("ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	require.NoError(t, err)
+	destroyed.Destroy()
+	require.Len(t, e.deviceHandles, 1)
+
+	// the handle of the Device still alive is destroyed with the engine
+	wengine.Destroy()
+	require.Empty(t, e.deviceHandles)
+	_, err = alive.GetDeviceID()
+	require.ErrorIs(t, err, ErrEngineClosed)
+	alive.Destroy()
+}
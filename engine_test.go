@@ -0,0 +1,104 @@
+package wurfl_test
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	wurfl "github.com/WURFL/golang-wurfl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWurfl_Reload(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+	require.NotNil(t, wengine)
+	defer wengine.Destroy()
+
+	_, err := wengine.LookupDeviceID("golang_wurfl_test_device")
+	assert.ErrorIs(t, err, wurfl.ErrDeviceNotFound)
+
+	// a device obtained before the reload must stay valid after it
+	oldDevice, err := wengine.LookupUserAgent("ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	require.NoError(t, err)
+
+	patch := filepath.Join(t.TempDir(), "patch.xml")
+	require.NoError(t, os.WriteFile(patch, []byte(testPatch), 0o600))
+
+	err = wengine.Reload(fixtureWurflZip(), []string{patch})
+	require.NoError(t, err)
+
+	device, err := wengine.LookupDeviceID("golang_wurfl_test_device")
+	require.NoError(t, err)
+	brand, err := device.GetStaticCap("brand_name")
+	assert.NoError(t, err)
+	assert.Equal(t, "GolangWurfl", brand)
+	device.Destroy()
+
+	deviceid, err := oldDevice.GetDeviceID()
+	assert.NoError(t, err)
+	assert.Equal(t, "apple_iphone_ver8_3_subuacfnetwork", deviceid)
+	oldDevice.Destroy()
+
+	assert.NotEmpty(t, wengine.ImportantHeaderNames)
+}
+
+func TestWurfl_ReloadFailureKeepsOldData(t *testing.T) {
+	patch := filepath.Join(t.TempDir(), "patch.xml")
+	require.NoError(t, os.WriteFile(patch, []byte(testPatch), 0o600))
+
+	wengine, err := wurfl.CreateWithOptions(fixtureWurflZip(), wurfl.WithPatches(patch), wurfl.WithCacheSize(100000))
+	require.NoError(t, err)
+	defer wengine.Destroy()
+
+	err = wengine.Reload("/nodir/wurfl.zip", nil)
+	assert.ErrorIs(t, err, wurfl.ErrFileNotFound)
+
+	err = wengine.Reload("", nil)
+	assert.ErrorIs(t, err, wurfl.ErrRootNotSet)
+
+	// the patched data is still active
+	device, err := wengine.LookupDeviceID("golang_wurfl_test_device")
+	require.NoError(t, err)
+	device.Destroy()
+}
+
+func TestWurfl_ReloadConcurrentLookups(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+	require.NotNil(t, wengine)
+	defer wengine.Destroy()
+
+	ihmap := map[string]string{
+		"User-Agent":         "Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Mobile Safari/537.36",
+		"Sec-CH-UA-Platform": "Android",
+		"Sec-CH-UA-Mobile":   "?1",
+	}
+
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				device, err := wengine.LookupWithImportantHeaderMap(ihmap)
+				if err != nil {
+					t.Errorf("lookup during reload failed: %v", err)
+					return
+				}
+				if _, err := device.GetVirtualCap("form_factor"); err != nil {
+					t.Errorf("GetVirtualCap during reload failed: %v", err)
+				}
+				device.Destroy()
+			}
+		}()
+	}
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, wengine.Reload(fixtureWurflZip(), nil))
+	}
+	stop.Store(true)
+	wg.Wait()
+}
@@ -69,6 +69,10 @@ var (
 	ErrNotZipFile                          = errors.New("file isn't a zip")
 )
 
+// ErrEngineClosed is returned by the Wurfl and Device methods once the engine has been
+// destroyed or shut down. It matches ErrInvalidHandle too, as returned by previous versions.
+var ErrEngineClosed = fmt.Errorf("wurfl: engine closed (%w)", ErrInvalidHandle)
+
 // wurflGoErrors maps C wurfl_error codes to Go error values by index.
 // The order and length must exactly match the wurfl_error enum in wurfl.h,
 // up to WURFL_ERROR_LAST.
@@ -168,10 +172,7 @@ func cErrorToGoError(cErr C.wurfl_error) error {
 		actualCMsg = fmt.Sprintf("wurfl: undefined error message for code %d", cErr)
 	}
 
-	errCodeInt := int(cErr)
-	// Check bounds and if the error is mapped in the slice from err.go
-	if errCodeInt > 0 && errCodeInt < len(wurflGoErrors) && wurflGoErrors[errCodeInt] != nil {
-		baseSentinelErr := wurflGoErrors[errCodeInt]
+	if baseSentinelErr := sentinelOf(cErr); baseSentinelErr != nil {
 		// Return our custom error type, which will use actualCMsg for .Error()
 		return &wurflError{sentinel: baseSentinelErr, msg: actualCMsg, code: cErr}
 	}
@@ -180,6 +181,28 @@ func cErrorToGoError(cErr C.wurfl_error) error {
 	return fmt.Errorf("%s (code %d)", actualCMsg, cErr)
 }
 
+// sentinelOf returns the sentinel Go error of a C error code, or nil if the code is not
+// mapped. The wurflGoErrors table is built from the wurfl.h the package is compiled with:
+// the codes returned by a libwurfl older than FeatureErrorMapping are not mapped.
+func sentinelOf(cErr C.wurfl_error) error {
+	errCodeInt := int(cErr)
+	// Check bounds and if the error is mapped in the slice from err.go
+	if errCodeInt <= 0 || errCodeInt >= len(wurflGoErrors) || wurflGoErrors[errCodeInt] == nil {
+		return nil
+	}
+	if !mapsErrors(linked()) {
+		return nil
+	}
+	return wurflGoErrors[errCodeInt]
+}
+
+// mapsErrors reports whether the error codes of the linked libwurfl can be mapped to the
+// sentinels. Only a libwurfl known to be older than FeatureErrorMapping is left unmapped:
+// when APIVersion() cannot be parsed the mapping is kept, and Features() returns the parse error.
+func mapsErrors(r *FeatureReport, err error) bool {
+	return err != nil || r.Has(FeatureErrorMapping)
+}
+
 // checkHandleError checks the error state on a WURFL handle after an operation
 // that doesn't directly return a wurfl_error but indicates failure via return value (e.g., NULL)
 // and sets the error state on the handle.
@@ -221,12 +244,27 @@ func checkHandleError(handle C.wurfl_handle) error {
 	}
 
 	// Try to get a pre-defined sentinel Go error for this code.
-	errCodeInt := int(errCode)
-	if errCodeInt > 0 && errCodeInt < len(wurflGoErrors) && wurflGoErrors[errCodeInt] != nil {
-		baseSentinelErr := wurflGoErrors[errCodeInt]
+	if baseSentinelErr := sentinelOf(errCode); baseSentinelErr != nil {
 		return &wurflError{sentinel: baseSentinelErr, msg: finalMsg, code: errCode}
 	}
 
 	// No sentinel error found (unmapped code) - create a standard Go error.
 	return fmt.Errorf("%s (code %d)", finalMsg, errCode)
 }
+
+// OptionError reports which Option passed to CreateWithOptions was invalid or could not be applied.
+// The wrapped error is one of the sentinel errors above, so errors.Is keeps working.
+type OptionError struct {
+	Option string // name of the Option, ie: "WithCacheSize"
+	Err    error  // the underlying error
+}
+
+// Error returns the option name followed by the underlying error message.
+func (e *OptionError) Error() string {
+	return "wurfl: " + e.Option + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error, allowing errors.Is to work.
+func (e *OptionError) Unwrap() error {
+	return e.Err
+}
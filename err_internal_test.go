@@ -0,0 +1,22 @@
+package wurfl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapsErrors(t *testing.T) {
+	old, err := FeaturesOf("1.12.9.3")
+	assert.NoError(t, err)
+	assert.False(t, mapsErrors(old, err))
+
+	recent, err := FeaturesOf("1.13.4.0")
+	assert.NoError(t, err)
+	assert.True(t, mapsErrors(recent, err))
+
+	// an unparsable version keeps the mapping
+	bad, err := FeaturesOf("unknown")
+	assert.ErrorIs(t, err, ErrInvalidVersion)
+	assert.True(t, mapsErrors(bad, err))
+}
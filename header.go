@@ -0,0 +1,207 @@
+package wurfl
+
+//
+//#include <wurfl/wurfl.h>
+import "C"
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sort"
+)
+
+// The lookups of this file take the headers as a multi-valued map: http.Header, and
+// textproto.MIMEHeader or gRPC metadata (map[string][]string) converted to it. Its keys do
+// not need to be canonical: they are matched case-insensitively with the important header
+// trie. As RFC 9110 section 5.3 allows, the values of a repeated list-valued header (see
+// listHeaders), including those of keys differing only by case, are joined with ", " in a
+// single value. Any other header, such as User-Agent or Sec-CH-UA-Platform, takes its first
+// value, reading keys differing only by case in sorted order. Empty values are skipped.
+
+// listHeaders are the important headers whose value is a comma-separated list
+var listHeaders = []string{
+	"Accept",
+	"Accept-Encoding",
+	"Accept-Language",
+	"Sec-CH-UA",
+	"Sec-CH-UA-Full-Version-List",
+}
+
+// isListHeader reports whether name is one of listHeaders, whatever its case
+func isListHeader(name string) bool {
+	return slices.ContainsFunc(listHeaders, func(l string) bool { return tokenEqualFold(l, name) })
+}
+
+// headerValues returns the value of each important header found in h, in the order of hs.names
+func (hs *headerSet) headerValues(h http.Header) []string {
+	var keys []string
+	for key := range h {
+		if _, found := hs.trie.get(key); found {
+			keys = append(keys, key)
+		}
+	}
+	// keys differing only by case are read in a stable order
+	sort.Strings(keys)
+
+	values := make([]string, len(hs.names))
+	for _, key := range keys {
+		cname, _ := hs.trie.get(key)
+		i := hs.index[cname]
+		if hs.list[i] {
+			values[i] = joinHeaderValues(values[i], h[key])
+		} else if values[i] == "" {
+			values[i] = firstHeaderValue(h[key])
+		}
+	}
+	return values
+}
+
+// setHeader fills cih with the important headers found in h
+func (hs *headerSet) setHeader(cih C.wurfl_important_header_handle, h http.Header) {
+	a := getHeaderArena()
+	defer a.release()
+	for i, value := range hs.headerValues(h) {
+		if value != "" {
+			C.wurfl_important_header_set(cih, hs.cnames[i], a.cString(value))
+		}
+	}
+}
+
+// joinHeaderValues appends the non-empty values to joined, separated by ", "
+func joinHeaderValues(joined string, values []string) string {
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		if joined != "" {
+			joined += ", "
+		}
+		joined += v
+	}
+	return joined
+}
+
+// firstHeaderValue returns the first non-empty of values
+func firstHeaderValue(values []string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// headerValue returns the first value of the name header in h, whatever the case of its keys
+func headerValue(h http.Header, name string) string {
+	var keys []string
+	for key := range h {
+		if tokenEqualFold(key, name) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if value := firstHeaderValue(h[key]); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// tokenEqualFold reports whether a and b are the same header name, ignoring the case of
+// ASCII letters only, as the important header trie does
+func tokenEqualFold(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		c := tokenIndex[a[i]]
+		if c == noToken || c != tokenIndex[b[i]] {
+			return false
+		}
+	}
+	return true
+}
+
+// LookupHeader : lookup using the important headers found in h and return Device handle.
+// Repeated list-valued headers are joined, see the comment at the top of header.go.
+func (w *Wurfl) LookupHeader(h http.Header) (*Device, error) {
+	if err := w.limiter.wait(context.Background()); err != nil {
+		return nil, err
+	}
+	defer w.limiter.done()
+
+	e := w.acquire()
+	if e == nil {
+		return nil, ErrEngineClosed
+	}
+	defer e.release()
+
+	cih := C.wurfl_important_header_create(e.handle)
+	if cih == nil {
+		return nil, checkHandleError(e.handle)
+	}
+	defer C.wurfl_important_header_destroy(cih)
+	e.headers.Load().setHeader(cih, h)
+
+	d := e.newDevice()
+	d.Device = C.wurfl_lookup_with_important_header(e.handle, cih)
+	return d.lookupDone()
+}
+
+// LookupDeviceIDWithHeader : lookup by wurfl_ID and the important headers found in h and
+// return Device handle. Repeated list-valued headers are joined, see the comment at the top
+// of header.go.
+func (w *Wurfl) LookupDeviceIDWithHeader(DeviceID string, h http.Header) (*Device, error) {
+	if err := w.limiter.wait(context.Background()); err != nil {
+		return nil, err
+	}
+	defer w.limiter.done()
+
+	e := w.acquire()
+	if e == nil {
+		return nil, ErrEngineClosed
+	}
+	defer e.release()
+
+	cDeviceID := cString(DeviceID)
+	defer cFree(cDeviceID)
+
+	cih := C.wurfl_important_header_create(e.handle)
+	if cih == nil {
+		return nil, checkHandleError(e.handle)
+	}
+	defer C.wurfl_important_header_destroy(cih)
+	e.headers.Load().setHeader(cih, h)
+
+	d := e.newDevice()
+	d.Device = C.wurfl_get_device_with_important_header(e.handle, cDeviceID, cih)
+	return d.lookupDone()
+}
+
+// GetHeaderQualityWithHeader is GetHeaderQuality for the headers in h
+func (w *Wurfl) GetHeaderQualityWithHeader(h http.Header) (HeaderQuality, error) {
+	e := w.acquire()
+	if e == nil {
+		return HeaderQualityNone, ErrEngineClosed
+	}
+	defer e.release()
+
+	cih := C.wurfl_important_header_create(e.handle)
+	if cih == nil {
+		return HeaderQualityNone, checkHandleError(e.handle)
+	}
+	defer C.wurfl_important_header_destroy(cih)
+	e.headers.Load().setHeader(cih, h)
+
+	hq := C.wurfl_important_header_uach_quality(cih)
+	return HeaderQuality(hq), nil
+}
+
+// IsUserAgentFrozenWithHeader returns true if the User-Agent header of h is frozen.
+// A repeated User-Agent header takes its first value, see the comment at the top of header.go.
+func (w *Wurfl) IsUserAgentFrozenWithHeader(h http.Header) bool {
+	return w.IsUserAgentFrozen(headerValue(h, "User-Agent"))
+}
@@ -0,0 +1,39 @@
+package wurfl
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaderSet_HeaderValues(t *testing.T) {
+	wengine, err := CreateWithOptions(testWurflZip())
+	require.NoError(t, err)
+	defer wengine.Destroy()
+
+	e := wengine.acquire()
+	require.NotNil(t, e)
+	defer e.release()
+	hs := e.headers.Load()
+
+	h := http.Header{
+		"User-Agent":         {"ArtDeviant/3.0.2", "CFNetwork/711.3.18 Darwin/14.0.0"},
+		"Sec-Ch-Ua":          {`"Chromium";v="118"`, `"Google Chrome";v="118"`},
+		"sec-ch-ua":          {`"Not=A?Brand";v="99"`},
+		"Sec-Ch-Ua-Platform": {"", `"Android"`, `"Linux"`},
+	}
+	want := map[string]string{
+		"User-Agent":         "ArtDeviant/3.0.2",
+		"Sec-CH-UA":          `"Chromium";v="118", "Google Chrome";v="118", "Not=A?Brand";v="99"`,
+		"Sec-CH-UA-Platform": `"Android"`,
+	}
+	got := make(map[string]string)
+	for i, value := range hs.headerValues(h) {
+		if _, found := want[hs.names[i]]; found {
+			got[hs.names[i]] = value
+		}
+	}
+	assert.Equal(t, want, got)
+}
@@ -0,0 +1,114 @@
+package wurfl_test
+
+import (
+	"net/http"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWurfl_LookupHeader(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+	defer wengine.Destroy()
+
+	ua := "ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0"
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	req.Header.Set("User-Agent", ua)
+	device, err := wengine.LookupRequest(req)
+	require.NoError(t, err)
+	want, err := device.GetDeviceID()
+	require.NoError(t, err)
+	device.Destroy()
+
+	headers := map[string]http.Header{
+		"http.Header": req.Header,
+		// gRPC metadata keys are lower case
+		"metadata":             http.Header(map[string][]string{"user-agent": {ua}}),
+		"textproto.MIMEHeader": http.Header(textproto.MIMEHeader{"User-Agent": {ua}}),
+		"empty values skipped": {"User-Agent": {"", ua, ""}},
+	}
+	for name, h := range headers {
+		device, err := wengine.LookupHeader(h)
+		require.NoError(t, err, name)
+		id, err := device.GetDeviceID()
+		assert.NoError(t, err, name)
+		assert.Equal(t, want, id, name)
+		original, err := device.GetOriginalUserAgent()
+		assert.NoError(t, err, name)
+		assert.Equal(t, ua, original, name)
+		device.Destroy()
+	}
+
+	device, err = wengine.LookupDeviceIDWithHeader("generic", req.Header)
+	require.NoError(t, err)
+	id, err := device.GetDeviceID()
+	assert.NoError(t, err)
+	assert.Equal(t, "generic", id)
+	device.Destroy()
+
+	_, err = wengine.LookupDeviceIDWithHeader("not_a_device_id", req.Header)
+	assert.Error(t, err)
+}
+
+func TestWurfl_LookupHeader_RepeatedValues(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+	defer wengine.Destroy()
+
+	headers := map[string]http.Header{
+		"repeated header": {"User-Agent": {"ArtDeviant/3.0.2", "CFNetwork/711.3.18 Darwin/14.0.0"}},
+		// read in the order of the keys, upper case first
+		"keys differing by case": {"user-agent": {"CFNetwork/711.3.18 Darwin/14.0.0"}, "User-Agent": {"ArtDeviant/3.0.2"}},
+		"empty first value":      {"User-Agent": {"", "ArtDeviant/3.0.2"}},
+	}
+	for name, h := range headers {
+		// User-Agent is not a list: it takes its first value
+		device, err := wengine.LookupHeader(h)
+		require.NoError(t, err, name)
+		original, err := device.GetOriginalUserAgent()
+		assert.NoError(t, err, name)
+		assert.Equal(t, "ArtDeviant/3.0.2", original, name)
+		device.Destroy()
+
+		assert.Equal(t, wengine.IsUserAgentFrozen("ArtDeviant/3.0.2"), wengine.IsUserAgentFrozenWithHeader(h), name)
+	}
+}
+
+func TestWurfl_GetHeaderQualityWithHeader(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+	defer wengine.Destroy()
+
+	headers := []http.Header{
+		{"User-Agent": {"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/96.0.4664.93 Safari/537.36"}},
+		{
+			"User-Agent":         {"Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Mobile Safari/537.36"},
+			"Sec-Ch-Ua":          {`"Chromium";v="118", "Google Chrome";v="118", "Not=A?Brand";v="99"`},
+			"Sec-Ch-Ua-Platform": {`"Android"`},
+			"Sec-Ch-Ua-Mobile":   {"?1"},
+		},
+	}
+	for _, h := range headers {
+		req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+		req.Header = h
+		want, err := wengine.GetHeaderQuality(req)
+		require.NoError(t, err)
+
+		got, err := wengine.GetHeaderQualityWithHeader(h)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+
+		lower := http.Header{}
+		for name, values := range h {
+			lower[strings.ToLower(name)] = values
+		}
+		got, err = wengine.GetHeaderQualityWithHeader(lower)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	ua := "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	assert.Equal(t, wengine.IsUserAgentFrozen(ua), wengine.IsUserAgentFrozenWithHeader(http.Header{"user-agent": {ua}}))
+	assert.False(t, wengine.IsUserAgentFrozenWithHeader(nil))
+}
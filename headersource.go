@@ -0,0 +1,111 @@
+package wurfl
+
+//
+//#include <wurfl/wurfl.h>
+import "C"
+
+import (
+	"context"
+)
+
+// HeaderSource gives access to the headers of a request for servers not built on net/http,
+// such as fasthttp, whose *RequestHeader implements it. Peek returns the value of the name
+// header, or nil when there is none; name is one of the important header names in their
+// canonical form (ie: "User-Agent"), matching them case-insensitively is up to the source.
+// The returned bytes are copied before Peek is called again and are not retained.
+type HeaderSource interface {
+	Peek(name string) []byte
+}
+
+// HeaderSourceFunc adapts a function to the HeaderSource interface
+type HeaderSourceFunc func(name string) []byte
+
+// Peek returns f(name)
+func (f HeaderSourceFunc) Peek(name string) []byte {
+	return f(name)
+}
+
+// setHeaderSource fills cih with the values of the important headers peeked from src,
+// copying them straight from the returned bytes to the arena
+func (hs *headerSet) setHeaderSource(cih C.wurfl_important_header_handle, src HeaderSource) {
+	a := getHeaderArena()
+	defer a.release()
+	for i, name := range hs.names {
+		if value := src.Peek(name); len(value) != 0 {
+			C.wurfl_important_header_set(cih, hs.cnames[i], a.cStringBytes(value))
+		}
+	}
+}
+
+// LookupHeaderSource : lookup using the important headers peeked from src and return Device handle
+func (w *Wurfl) LookupHeaderSource(src HeaderSource) (*Device, error) {
+	if err := w.limiter.wait(context.Background()); err != nil {
+		return nil, err
+	}
+	defer w.limiter.done()
+
+	e := w.acquire()
+	if e == nil {
+		return nil, ErrEngineClosed
+	}
+	defer e.release()
+
+	cih := C.wurfl_important_header_create(e.handle)
+	if cih == nil {
+		return nil, checkHandleError(e.handle)
+	}
+	defer C.wurfl_important_header_destroy(cih)
+	e.headers.Load().setHeaderSource(cih, src)
+
+	d := e.newDevice()
+	d.Device = C.wurfl_lookup_with_important_header(e.handle, cih)
+	return d.lookupDone()
+}
+
+// LookupDeviceIDWithHeaderSource : lookup by wurfl_ID and the important headers peeked
+// from src and return Device handle
+func (w *Wurfl) LookupDeviceIDWithHeaderSource(DeviceID string, src HeaderSource) (*Device, error) {
+	if err := w.limiter.wait(context.Background()); err != nil {
+		return nil, err
+	}
+	defer w.limiter.done()
+
+	e := w.acquire()
+	if e == nil {
+		return nil, ErrEngineClosed
+	}
+	defer e.release()
+
+	cDeviceID := cString(DeviceID)
+	defer cFree(cDeviceID)
+
+	cih := C.wurfl_important_header_create(e.handle)
+	if cih == nil {
+		return nil, checkHandleError(e.handle)
+	}
+	defer C.wurfl_important_header_destroy(cih)
+	e.headers.Load().setHeaderSource(cih, src)
+
+	d := e.newDevice()
+	d.Device = C.wurfl_get_device_with_important_header(e.handle, cDeviceID, cih)
+	return d.lookupDone()
+}
+
+// GetHeaderQualityWithHeaderSource is GetHeaderQuality for the headers peeked from src
+func (w *Wurfl) GetHeaderQualityWithHeaderSource(src HeaderSource) (HeaderQuality, error) {
+	e := w.acquire()
+	if e == nil {
+		return HeaderQualityNone, ErrEngineClosed
+	}
+	defer e.release()
+
+	cih := C.wurfl_important_header_create(e.handle)
+	if cih == nil {
+		return HeaderQualityNone, checkHandleError(e.handle)
+	}
+	defer C.wurfl_important_header_destroy(cih)
+	e.headers.Load().setHeaderSource(cih, src)
+
+	hq := C.wurfl_important_header_uach_quality(cih)
+	return HeaderQuality(hq), nil
+}
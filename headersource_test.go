@@ -0,0 +1,93 @@
+package wurfl_test
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	wurfl "github.com/WURFL/golang-wurfl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// peekHeaders mimics the fasthttp request headers: raw lines, peeked case-insensitively
+type peekHeaders struct {
+	lines  [][]byte
+	peeked []string
+}
+
+func (p *peekHeaders) Peek(name string) []byte {
+	p.peeked = append(p.peeked, name)
+	for _, line := range p.lines {
+		key, value, found := bytes.Cut(line, []byte(": "))
+		if found && bytes.EqualFold(key, []byte(name)) {
+			return value
+		}
+	}
+	return nil
+}
+
+func TestWurfl_LookupHeaderSource(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+	defer wengine.Destroy()
+
+	ua := "ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0"
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	req.Header.Set("User-Agent", ua)
+	device, err := wengine.LookupRequest(req)
+	require.NoError(t, err)
+	want, err := device.GetDeviceID()
+	require.NoError(t, err)
+	device.Destroy()
+
+	src := &peekHeaders{lines: [][]byte{[]byte("user-agent: " + ua), []byte("Accept: */*")}}
+	device, err = wengine.LookupHeaderSource(src)
+	require.NoError(t, err)
+	id, err := device.GetDeviceID()
+	assert.NoError(t, err)
+	assert.Equal(t, want, id)
+	original, err := device.GetOriginalUserAgent()
+	assert.NoError(t, err)
+	assert.Equal(t, ua, original)
+	device.Destroy()
+
+	// only the important headers are peeked, each once
+	assert.Equal(t, wengine.GetImportantHeaderNames(), src.peeked)
+
+	device, err = wengine.LookupDeviceIDWithHeaderSource("generic", src)
+	require.NoError(t, err)
+	id, err = device.GetDeviceID()
+	assert.NoError(t, err)
+	assert.Equal(t, "generic", id)
+	device.Destroy()
+
+	_, err = wengine.LookupDeviceIDWithHeaderSource("not_a_device_id", src)
+	assert.Error(t, err)
+}
+
+func TestWurfl_GetHeaderQualityWithHeaderSource(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+	defer wengine.Destroy()
+
+	h := http.Header{
+		"User-Agent":         {"Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Mobile Safari/537.36"},
+		"Sec-Ch-Ua":          {`"Chromium";v="118", "Google Chrome";v="118", "Not=A?Brand";v="99"`},
+		"Sec-Ch-Ua-Platform": {`"Android"`},
+		"Sec-Ch-Ua-Mobile":   {"?1"},
+	}
+	want, err := wengine.GetHeaderQualityWithHeader(h)
+	require.NoError(t, err)
+
+	got, err := wengine.GetHeaderQualityWithHeaderSource(wurfl.HeaderSourceFunc(func(name string) []byte {
+		if value := h.Get(name); value != "" {
+			return []byte(value)
+		}
+		return nil
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	got, err = wengine.GetHeaderQualityWithHeaderSource(wurfl.HeaderSourceFunc(func(string) []byte { return nil }))
+	require.NoError(t, err)
+	assert.Equal(t, wurfl.HeaderQualityNone, got)
+}
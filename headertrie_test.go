@@ -0,0 +1,125 @@
+package wurfl_test
+
+import (
+	"testing"
+
+	wurfl "github.com/WURFL/golang-wurfl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var trieHeaderNames = []string{
+	"Accept-Encoding", "Device-Stock-UA", "Sec-CH-UA", "Sec-CH-UA-Full-Version-List",
+	"Sec-CH-UA-Platform", "User-Agent", "X-OperaMini-Phone-UA", "X-UCBrowser-Device-UA",
+	// every token special character
+	"X-!#$%&'*+-.^_`|~",
+}
+
+// asciiEqualFold is the reference case-insensitive comparison of header names: unlike
+// strings.EqualFold, it does not fold non-ASCII runes such as the Kelvin sign into 'k'
+func asciiEqualFold(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		ca, cb := a[i], b[i]
+		if 'A' <= ca && ca <= 'Z' {
+			ca += 'a' - 'A'
+		}
+		if 'A' <= cb && cb <= 'Z' {
+			cb += 'a' - 'A'
+		}
+		if ca != cb {
+			return false
+		}
+	}
+	return true
+}
+
+func isKnownHeader(key string) bool {
+	for _, name := range trieHeaderNames {
+		if asciiEqualFold(key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestHeaderTrie(t *testing.T) {
+	trieGet := wurfl.BenchmarkableTrieGet(trieHeaderNames)
+
+	for _, name := range trieHeaderNames {
+		assert.NotNil(t, trieGet(name), name)
+	}
+	assert.NotNil(t, trieGet("user-agent"))
+	assert.NotNil(t, trieGet("USER-AGENT"))
+	assert.NotNil(t, trieGet("sEc-cH-uA-fUlL-vErSiOn-LiSt"))
+	assert.Equal(t, trieGet("User-Agent"), trieGet("uSER-aGENT"))
+
+	notFound := []string{
+		"",
+		"User-Agen",
+		"User-Agent2",
+		"User_Agent",         // '_' used to fold to DEL
+		"X-!#$%&'*+-.~_`|~",  // '^' and '~' used to share a slot
+		"X-!#$%&'*+-.^_`\\~", // '\\' used to fold to '|'
+		"User-Agent\x00",
+		"User Agent",
+		"User-Agent:",
+		"Us\xc3\xa9r-Agent",
+		"\xff\xfe\x80",
+		"Device-Stoc\u212a-UA", // Kelvin sign, folded to 'k' by Unicode rules
+		"USER\x0dAGENT",        // '\r' | 0x20 is '-'
+	}
+	for _, key := range notFound {
+		assert.Nil(t, trieGet(key), "%q", key)
+	}
+}
+
+func TestHeaderTrie_ZeroAlloc(t *testing.T) {
+	trieGet := wurfl.BenchmarkableTrieGet(trieHeaderNames)
+	keys := []string{"Sec-CH-UA-Full-Version-List", "user-agent", "X-Unknown", "\xff\x80", "User Agent"}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		for _, key := range keys {
+			benchSink = trieGet(key)
+		}
+	})
+	assert.Zero(t, allocs)
+}
+
+func FuzzHeaderTrie(f *testing.F) {
+	for _, name := range trieHeaderNames {
+		f.Add(name)
+	}
+	f.Add("user_agent")
+	f.Add("\xff\xfe")
+	f.Add("Sec-CH-UA\x00")
+
+	trieGet := wurfl.BenchmarkableTrieGet(trieHeaderNames)
+	f.Fuzz(func(t *testing.T, key string) {
+		found := trieGet(key) != nil
+		if found != isKnownHeader(key) {
+			t.Fatalf("trie get(%q) = %v, want %v", key, found, !found)
+		}
+	})
+}
+
+func FuzzLookupWithImportantHeaderMap(f *testing.F) {
+	f.Add("User-Agent", "ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	f.Add("sec-ch-ua-platform", `"Android"`)
+	f.Add("\xff\x80\x00", "x")
+	f.Add("User_Agent", "")
+
+	wengine, err := wurfl.Create(fixtureWurflZip(), nil, nil, -1, wurfl.WurflCacheProviderLru, "100")
+	require.NoError(f, err)
+	defer wengine.Destroy()
+
+	f.Fuzz(func(t *testing.T, name, value string) {
+		device, err := wengine.LookupWithImportantHeaderMap(map[string]string{name: value})
+		if err != nil {
+			t.Fatal(err)
+		}
+		device.Destroy()
+	})
+}
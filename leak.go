@@ -0,0 +1,212 @@
+package wurfl
+
+import (
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// HandleStats counts the Wurfl and Device handles of the process. Live handles are always
+// counted; leaked ones are the handles released by a finalizer, see WithFinalizers.
+type HandleStats struct {
+	LiveEngines   int64 `json:"live_engines"`   // Wurfl created and not destroyed yet
+	LiveDevices   int64 `json:"live_devices"`   // Device looked up and not destroyed yet
+	LeakedEngines int64 `json:"leaked_engines"` // Wurfl garbage collected without Destroy or Shutdown
+	LeakedDevices int64 `json:"leaked_devices"` // Device garbage collected without Destroy
+}
+
+var handleStats struct {
+	liveEngines, liveDevices, leakedEngines, leakedDevices atomic.Int64
+}
+
+// Handles returns the current handle counters
+func Handles() HandleStats {
+	return HandleStats{
+		LiveEngines:   handleStats.liveEngines.Load(),
+		LiveDevices:   handleStats.liveDevices.Load(),
+		LeakedEngines: handleStats.leakedEngines.Load(),
+		LeakedDevices: handleStats.leakedDevices.Load(),
+	}
+}
+
+// Leak describes a handle that has been garbage collected without being destroyed
+type Leak struct {
+	Kind  string // "Wurfl" or "Device"
+	Stack string // where the handle was created, empty unless WithAllocationStacks is used
+}
+
+var leakHandler atomic.Pointer[func(Leak)]
+
+// SetLeakHandler sets the function called, from the finalizer goroutine, for every leaked
+// handle found by the finalizers. A nil f removes the handler.
+func SetLeakHandler(f func(Leak)) {
+	if f == nil {
+		leakHandler.Store(nil)
+		return
+	}
+	leakHandler.Store(&f)
+}
+
+// leakMode is the leak detection enabled on an engine
+type leakMode struct {
+	finalizers bool // set finalizers on the Wurfl and its Devices
+	stacks     bool // record the stack of each allocation
+}
+
+// WithFinalizers sets runtime finalizers on the Wurfl and on its Devices: a handle garbage
+// collected without Destroy is released by its finalizer, counted as leaked in Handles and
+// passed to the leak handler (see SetLeakHandler). Finalizers run at the garbage collector
+// pace, so they are a safety net, not a replacement for Destroy.
+func WithFinalizers() Option {
+	return func(o *options) error {
+		o.leaks.finalizers = true
+		return nil
+	}
+}
+
+// WithAllocationStacks is a debug mode recording the stack of every Wurfl and Device
+// creation, reported in the Leak of a leaked handle and by LiveDeviceStacks. It implies
+// WithFinalizers, and makes the lookups noticeably slower.
+func WithAllocationStacks() Option {
+	return func(o *options) error {
+		o.leaks = leakMode{finalizers: true, stacks: true}
+		return nil
+	}
+}
+
+// allocation is the creation stack of a handle, formatted when needed
+type allocation struct {
+	pcs      []uintptr
+	external bool // String skips the leading frames of the package, see callerAllocation
+}
+
+// packagePrefix starts the names of the functions of the package
+var packagePrefix = reflect.TypeOf(allocation{}).PkgPath() + "."
+
+// liveDevices holds the allocation of every live Device recorded with WithAllocationStacks.
+// It must not reference the Device itself, which would never be garbage collected.
+var liveDevices sync.Map // *allocation -> struct{}
+
+// newAllocation records the stack of the caller of the function calling it, skipping skip more frames
+func newAllocation(skip int) *allocation {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3+skip, pcs)
+	return &allocation{pcs: pcs[:n]}
+}
+
+// callerAllocation records the stack of the code outside of the package that called it,
+// however deep the lookup or creation function it went through
+func callerAllocation() *allocation {
+	a := newAllocation(0)
+	a.external = true
+	return a
+}
+
+func (a *allocation) String() string {
+	if a == nil {
+		return ""
+	}
+	var sb strings.Builder
+	frames := runtime.CallersFrames(a.pcs)
+	skip := a.external
+	for {
+		f, more := frames.Next()
+		if skip = skip && strings.HasPrefix(f.Function, packagePrefix); skip && more {
+			continue
+		}
+		sb.WriteString(f.Function)
+		sb.WriteString("\n\t")
+		sb.WriteString(f.File)
+		sb.WriteString(":")
+		sb.WriteString(strconv.Itoa(f.Line))
+		sb.WriteString("\n")
+		if !more {
+			break
+		}
+	}
+	return sb.String()
+}
+
+// LiveDeviceStacks returns, for the Devices created with WithAllocationStacks and not
+// destroyed yet, how many were created from each stack. A count growing over time
+// points to the code forgetting to destroy its devices.
+func LiveDeviceStacks() map[string]int {
+	stacks := make(map[string]int)
+	liveDevices.Range(func(k, _ any) bool {
+		stacks[k.(*allocation).String()]++
+		return true
+	})
+	return stacks
+}
+
+func reportLeak(kind string, alloc *allocation) {
+	if f := leakHandler.Load(); f != nil {
+		(*f)(Leak{Kind: kind, Stack: alloc.String()})
+	}
+}
+
+// track counts d as live and sets up the leak detection of its engine
+func (d *Device) track(mode leakMode) {
+	handleStats.liveDevices.Add(1)
+	if mode.stacks {
+		d.alloc = callerAllocation()
+		liveDevices.Store(d.alloc, struct{}{})
+	}
+	if mode.finalizers {
+		runtime.SetFinalizer(d, finalizeDevice)
+	}
+}
+
+// untrack undoes track when d is destroyed
+func (d *Device) untrack() {
+	if d.alloc != nil {
+		liveDevices.Delete(d.alloc)
+		d.alloc = nil
+	}
+	runtime.SetFinalizer(d, nil)
+	handleStats.liveDevices.Add(-1)
+}
+
+// testHookDeviceCall, set by the tests, runs in GetRootID between the libwurfl call and the
+// read of its result: a finalizer destroying the Device in this window would free the result
+var testHookDeviceCall func()
+
+func finalizeDevice(d *Device) {
+	if d.engine == nil {
+		return
+	}
+	handleStats.leakedDevices.Add(1)
+	reportLeak("Device", d.alloc)
+	d.Destroy()
+}
+
+// track counts w as live and sets up its leak detection
+func (w *Wurfl) track(mode leakMode) {
+	handleStats.liveEngines.Add(1)
+	if mode.stacks {
+		w.alloc = callerAllocation()
+	}
+	if mode.finalizers {
+		runtime.SetFinalizer(w, finalizeWurfl)
+	}
+}
+
+// untrack undoes track when w is destroyed or shut down
+func (w *Wurfl) untrack() {
+	handleStats.liveEngines.Add(-1)
+	w.alloc = nil
+	runtime.SetFinalizer(w, nil)
+}
+
+// finalizeWurfl drains a forgotten Wurfl: its engine is freed once its Devices are destroyed
+func finalizeWurfl(w *Wurfl) {
+	if w.engine.Load() == nil {
+		return
+	}
+	handleStats.leakedEngines.Add(1)
+	reportLeak("Wurfl", w.alloc)
+	w.drain()
+}
@@ -0,0 +1,64 @@
+package wurfl
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestWithFinalizers_UnreferencedDevice runs the garbage collector in GetRootID, after the
+// libwurfl call and before its result is read, on Devices the caller no longer references:
+// the finalizer must not destroy a Device during a call on it.
+func TestWithFinalizers_UnreferencedDevice(t *testing.T) {
+	wengine, err := CreateWithOptions(testWurflZip(), WithFinalizers())
+	require.NoError(t, err)
+	defer wengine.Destroy()
+
+	leaks := make(chan Leak, 16)
+	SetLeakHandler(func(l Leak) { leaks <- l })
+	defer SetLeakHandler(nil)
+
+	ua := "ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0"
+	device, err := wengine.LookupUserAgent(ua)
+	require.NoError(t, err)
+	rootID := device.GetRootID()
+	device.Destroy()
+
+	testHookDeviceCall = func() {
+		runtime.GC()
+		select {
+		case <-leaks:
+			t.Error("Device finalized during GetRootID")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	defer func() { testHookDeviceCall = nil }()
+
+	// waitFinalized waits for the finalizer of the Device of the last iteration
+	waitFinalized := func() bool {
+		deadline := time.After(5 * time.Second)
+		for {
+			runtime.GC()
+			select {
+			case <-leaks:
+				return true
+			case <-deadline:
+				return false
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+
+	lookup := func() *Device {
+		device, err := wengine.LookupUserAgent(ua)
+		require.NoError(t, err)
+		return device
+	}
+	for i := 0; i < 20 && !t.Failed(); i++ {
+		assert.Equal(t, rootID, lookup().GetRootID())
+		require.True(t, waitFinalized(), "the unreferenced Device was not finalized")
+	}
+}
@@ -0,0 +1,119 @@
+package wurfl_test
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	wurfl "github.com/WURFL/golang-wurfl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandles(t *testing.T) {
+	before := wurfl.Handles()
+
+	wengine := fixtureCreateEngine(t)
+	device, err := wengine.LookupUserAgent("ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	require.NoError(t, err)
+
+	stats := wurfl.Handles()
+	assert.Equal(t, before.LiveEngines+1, stats.LiveEngines)
+	assert.Equal(t, before.LiveDevices+1, stats.LiveDevices)
+
+	// a failed lookup does not leave a live device behind
+	_, err = wengine.LookupDeviceID("not_a_device_id")
+	assert.Error(t, err)
+	assert.Equal(t, before.LiveDevices+1, wurfl.Handles().LiveDevices)
+
+	device.Destroy()
+	device.Destroy()
+	wengine.Destroy()
+	wengine.Destroy()
+
+	stats = wurfl.Handles()
+	assert.Equal(t, before.LiveEngines, stats.LiveEngines)
+	assert.Equal(t, before.LiveDevices, stats.LiveDevices)
+}
+
+// leakDevice looks up a device and forgets to destroy it
+func leakDevice(t *testing.T, wengine *wurfl.Wurfl) {
+	_, err := wengine.LookupUserAgent("ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	require.NoError(t, err)
+}
+
+// leakEngine creates an engine and forgets to destroy it
+func leakEngine(t *testing.T) {
+	_, err := wurfl.CreateWithOptions(fixtureWurflZip(), wurfl.WithFinalizers())
+	require.NoError(t, err)
+}
+
+// waitLeak runs the garbage collector until a leak of the given kind is reported
+func waitLeak(t *testing.T, leaks <-chan wurfl.Leak, kind string) wurfl.Leak {
+	deadline := time.After(10 * time.Second)
+	for {
+		runtime.GC()
+		select {
+		case leak := <-leaks:
+			if leak.Kind == kind {
+				return leak
+			}
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("no %s leak reported", kind)
+		}
+	}
+}
+
+func TestWithAllocationStacks(t *testing.T) {
+	leaks := make(chan wurfl.Leak, 16)
+	wurfl.SetLeakHandler(func(l wurfl.Leak) { leaks <- l })
+	defer wurfl.SetLeakHandler(nil)
+
+	wengine, err := wurfl.CreateWithOptions(fixtureWurflZip(), wurfl.WithAllocationStacks())
+	require.NoError(t, err)
+	defer wengine.Destroy()
+
+	before := wurfl.Handles()
+	leakDevice(t, wengine)
+
+	// the live device can be traced back to leakDevice before it is garbage collected
+	found := false
+	for stack := range wurfl.LiveDeviceStacks() {
+		if strings.Contains(stack, "leakDevice") {
+			found = true
+			assert.False(t, strings.HasPrefix(stack, "github.com/WURFL/golang-wurfl.(*Wurfl)"), stack)
+		}
+	}
+	assert.True(t, found)
+
+	leak := waitLeak(t, leaks, "Device")
+	assert.Contains(t, leak.Stack, "leakDevice")
+
+	assert.GreaterOrEqual(t, wurfl.Handles().LeakedDevices, before.LeakedDevices+1)
+	// the handler is called before the finalizer destroys the device
+	assert.Eventually(t, func() bool { return wurfl.Handles().LiveDevices == before.LiveDevices },
+		time.Second, time.Millisecond)
+	for stack := range wurfl.LiveDeviceStacks() {
+		assert.NotContains(t, stack, "leakDevice")
+	}
+}
+
+func TestWithFinalizers(t *testing.T) {
+	leaks := make(chan wurfl.Leak, 16)
+	wurfl.SetLeakHandler(func(l wurfl.Leak) { leaks <- l })
+	defer wurfl.SetLeakHandler(nil)
+
+	before := wurfl.Handles()
+	leakEngine(t)
+	assert.Equal(t, before.LiveEngines+1, wurfl.Handles().LiveEngines)
+
+	leak := waitLeak(t, leaks, "Wurfl")
+	// stacks are only recorded by WithAllocationStacks
+	assert.Empty(t, leak.Stack)
+
+	assert.GreaterOrEqual(t, wurfl.Handles().LeakedEngines, before.LeakedEngines+1)
+	assert.Eventually(t, func() bool { return wurfl.Handles().LiveEngines == before.LiveEngines },
+		time.Second, time.Millisecond)
+}
@@ -0,0 +1,187 @@
+package wurfl
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrLookupQueueFull is returned by a lookup finding every slot busy and the queue full,
+	// see WithLookupQueue
+	ErrLookupQueueFull = errors.New("wurfl: lookup queue full")
+	// ErrLookupQueueTimeout is returned by a lookup that waited in the queue longer than
+	// the queue timeout, see WithLookupQueue
+	ErrLookupQueueTimeout = errors.New("wurfl: lookup queue timeout")
+)
+
+// Every goroutine entering libwurfl through cgo holds an OS thread for the duration of the
+// call: under a traffic spike, thousands of concurrent lookups make the runtime spawn as
+// many threads. The limiter bounds the lookups running at once, the others wait for a free
+// slot in a queue, in FIFO order as far as the Go scheduler goes. It limits lookup
+// concurrency only: the other cgo calls, shorter, are not counted, so the threads they
+// hold come on top of the limit.
+
+// WithMaxConcurrentLookups limits the lookups running at once in libwurfl to n; it is not a
+// limit on every cgo call. The lookups started when every slot is busy wait for one in a
+// queue, unbounded and without timeout unless WithLookupQueue is used. Only the calls
+// returning Devices are limited: the Lookup and Detect methods, and the batch lookups, which
+// take one slot per batch. The Device methods (capability getters and Detection included,
+// also when called by the Detect methods once their lookup is done), GetHeaderQuality,
+// IsUserAgentFrozen and their variants are short calls that are not limited, nor is the
+// lookup of the fallback device returned when the context of a context lookup ends (see
+// WithFallbackDevice).
+func WithMaxConcurrentLookups(n int) Option {
+	return func(o *options) error {
+		if n <= 0 {
+			return &OptionError{Option: "WithMaxConcurrentLookups", Err: ErrInvalidParameter}
+		}
+		o.limit.maxLookups = n
+		return nil
+	}
+}
+
+// WithLookupQueue bounds the queue of the lookups waiting for a slot (see
+// WithMaxConcurrentLookups): a lookup finding size lookups already waiting fails with
+// ErrLookupQueueFull, and one waiting longer than timeout fails with ErrLookupQueueTimeout.
+// A size of 0 makes the lookups fail as soon as every slot is busy, a negative one leaves
+// the queue unbounded; a timeout of 0 waits without limit. It requires WithMaxConcurrentLookups.
+func WithLookupQueue(size int, timeout time.Duration) Option {
+	return func(o *options) error {
+		if timeout < 0 {
+			return &OptionError{Option: "WithLookupQueue", Err: ErrInvalidParameter}
+		}
+		o.limit.queueSize = max(size, -1)
+		o.limit.queueTimeout = timeout
+		o.limit.queueSet = true
+		return nil
+	}
+}
+
+// limitSettings are the limiter options
+type limitSettings struct {
+	maxLookups   int // 0 when unlimited
+	queueSize    int // -1 when unbounded
+	queueTimeout time.Duration
+	queueSet     bool // queueSize and queueTimeout have been set, the queue is unbounded otherwise
+}
+
+// LookupQueueStats are the counters of the lookup limiter of a Wurfl, see
+// WithMaxConcurrentLookups. They are all zero when the lookups are not limited.
+type LookupQueueStats struct {
+	MaxLookups int           `json:"max_lookups"` // lookups allowed to run at once
+	Running    int           `json:"running"`     // lookups running
+	Queued     int           `json:"queued"`      // lookups waiting for a slot
+	MaxQueued  int           `json:"max_queued"`  // highest number of lookups waiting at once so far
+	Admitted   int64         `json:"admitted"`    // lookups run so far, after waiting or not
+	Waited     int64         `json:"waited"`      // admitted lookups that had to wait for a slot
+	WaitTime   time.Duration `json:"wait_time"`   // total time waited by the admitted lookups
+	Rejected   int64         `json:"rejected"`    // lookups failed with ErrLookupQueueFull
+	TimedOut   int64         `json:"timed_out"`   // lookups failed with ErrLookupQueueTimeout
+	Canceled   int64         `json:"canceled"`    // context lookups whose context ended while waiting
+}
+
+// limiter bounds the lookups running at once, a nil limiter does not limit anything
+type limiter struct {
+	slots        chan struct{} // one entry per running lookup
+	queueSize    int           // -1 when unbounded
+	queueTimeout time.Duration
+
+	queued    atomic.Int64
+	maxQueued atomic.Int64
+	admitted  atomic.Int64
+	waited    atomic.Int64
+	waitTime  atomic.Int64
+	rejected  atomic.Int64
+	timedOut  atomic.Int64
+	canceled  atomic.Int64
+}
+
+// newLimiter returns the limiter of s, nil when the lookups are not limited
+func newLimiter(s limitSettings) *limiter {
+	if s.maxLookups == 0 {
+		return nil
+	}
+	l := &limiter{slots: make(chan struct{}, s.maxLookups), queueSize: -1}
+	if s.queueSet {
+		l.queueSize = s.queueSize
+		l.queueTimeout = s.queueTimeout
+	}
+	return l
+}
+
+// wait takes a slot, waiting for one until the queue timeout or the end of ctx. A
+// successful wait must be followed by done.
+func (l *limiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		l.admitted.Add(1)
+		return nil
+	default:
+	}
+
+	queued := l.queued.Add(1)
+	defer l.queued.Add(-1)
+	if l.queueSize >= 0 && queued > int64(l.queueSize) {
+		l.rejected.Add(1)
+		return ErrLookupQueueFull
+	}
+	for peak := l.maxQueued.Load(); queued > peak; peak = l.maxQueued.Load() {
+		if l.maxQueued.CompareAndSwap(peak, queued) {
+			break
+		}
+	}
+
+	var timeout <-chan time.Time
+	if l.queueTimeout > 0 {
+		timer := time.NewTimer(l.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	start := time.Now()
+	select {
+	case l.slots <- struct{}{}:
+		l.admitted.Add(1)
+		l.waited.Add(1)
+		l.waitTime.Add(int64(time.Since(start)))
+		return nil
+	case <-timeout:
+		l.timedOut.Add(1)
+		return ErrLookupQueueTimeout
+	case <-ctx.Done():
+		l.canceled.Add(1)
+		return ctx.Err()
+	}
+}
+
+// done releases the slot taken by wait
+func (l *limiter) done() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
+
+// LookupQueueStats returns the counters of the lookup limiter, see WithMaxConcurrentLookups
+func (w *Wurfl) LookupQueueStats() LookupQueueStats {
+	l := w.limiter
+	if l == nil {
+		return LookupQueueStats{}
+	}
+	return LookupQueueStats{
+		MaxLookups: cap(l.slots),
+		Running:    len(l.slots),
+		Queued:     int(l.queued.Load()),
+		MaxQueued:  int(l.maxQueued.Load()),
+		Admitted:   l.admitted.Load(),
+		Waited:     l.waited.Load(),
+		WaitTime:   time.Duration(l.waitTime.Load()),
+		Rejected:   l.rejected.Load(),
+		TimedOut:   l.timedOut.Load(),
+		Canceled:   l.canceled.Load(),
+	}
+}
@@ -0,0 +1,113 @@
+package wurfl
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitQueued waits until n lookups are queued on l
+func waitQueued(t *testing.T, l *limiter, n int64) {
+	assert.Eventually(t, func() bool { return l.queued.Load() == n }, 5*time.Second, time.Millisecond)
+}
+
+func TestLimiter_Queue(t *testing.T) {
+	l := newLimiter(limitSettings{maxLookups: 1, queueSize: 1, queueSet: true})
+
+	require.NoError(t, l.wait(context.Background()))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// waits for the running lookup
+		assert.NoError(t, l.wait(context.Background()))
+		l.done()
+	}()
+	waitQueued(t, l, 1)
+
+	// the queue is full
+	assert.ErrorIs(t, l.wait(context.Background()), ErrLookupQueueFull)
+
+	l.done()
+	wg.Wait()
+
+	w := &Wurfl{limiter: l}
+	stats := w.LookupQueueStats()
+	assert.Equal(t, 1, stats.MaxLookups)
+	assert.Zero(t, stats.Running)
+	assert.Zero(t, stats.Queued)
+	assert.Equal(t, 1, stats.MaxQueued)
+	assert.Equal(t, int64(2), stats.Admitted)
+	assert.Equal(t, int64(1), stats.Waited)
+	assert.Positive(t, stats.WaitTime)
+	assert.Equal(t, int64(1), stats.Rejected)
+}
+
+func TestLimiter_NoQueue(t *testing.T) {
+	l := newLimiter(limitSettings{maxLookups: 2, queueSize: 0, queueSet: true})
+
+	require.NoError(t, l.wait(context.Background()))
+	require.NoError(t, l.wait(context.Background()))
+	assert.ErrorIs(t, l.wait(context.Background()), ErrLookupQueueFull)
+	l.done()
+	assert.NoError(t, l.wait(context.Background()))
+}
+
+func TestLimiter_Timeout(t *testing.T) {
+	l := newLimiter(limitSettings{maxLookups: 1, queueSize: -1, queueTimeout: 10 * time.Millisecond, queueSet: true})
+
+	require.NoError(t, l.wait(context.Background()))
+	assert.ErrorIs(t, l.wait(context.Background()), ErrLookupQueueTimeout)
+	assert.Equal(t, int64(1), l.timedOut.Load())
+	assert.Zero(t, l.queued.Load())
+}
+
+func TestLimiter_Canceled(t *testing.T) {
+	l := newLimiter(limitSettings{maxLookups: 1})
+	require.NoError(t, l.wait(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() { errs <- l.wait(ctx) }()
+	waitQueued(t, l, 1)
+	cancel()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+	assert.Equal(t, int64(1), l.canceled.Load())
+}
+
+func TestLimiter_Nil(t *testing.T) {
+	assert.Nil(t, newLimiter(limitSettings{}))
+
+	var l *limiter
+	assert.NoError(t, l.wait(context.Background()))
+	l.done()
+	assert.Equal(t, LookupQueueStats{}, (&Wurfl{}).LookupQueueStats())
+}
+
+// TestWurfl_Fallback_NotLimited returns the fallback device while the limiter is full: its
+// lookup does not wait for a slot.
+func TestWurfl_Fallback_NotLimited(t *testing.T) {
+	wengine, err := CreateWithOptions(testWurflZip(),
+		WithMaxConcurrentLookups(1),
+		WithLookupQueue(0, 0),
+		WithFallbackDevice("generic"))
+	require.NoError(t, err)
+	defer wengine.Destroy()
+
+	require.NoError(t, wengine.limiter.wait(context.Background()))
+	defer wengine.limiter.done()
+	_, err = wengine.LookupDeviceID("generic")
+	require.ErrorIs(t, err, ErrLookupQueueFull)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	device, err := wengine.LookupUserAgentContext(ctx, "ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	require.NoError(t, err)
+	defer device.Destroy()
+	assert.True(t, device.IsFallback())
+}
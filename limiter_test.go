@@ -0,0 +1,104 @@
+package wurfl_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	wurfl "github.com/WURFL/golang-wurfl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithMaxConcurrentLookups(t *testing.T) {
+	wengine, err := wurfl.CreateWithOptions(fixtureWurflZip(),
+		wurfl.WithMaxConcurrentLookups(2),
+		wurfl.WithLookupQueue(100, time.Minute))
+	require.NoError(t, err)
+	defer wengine.Destroy()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				device, err := wengine.LookupUserAgentContext(context.Background(), "ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+				if !assert.NoError(t, err) {
+					return
+				}
+				device.Destroy()
+				device, err = wengine.LookupDeviceID("generic")
+				if !assert.NoError(t, err) {
+					return
+				}
+				device.Destroy()
+			}
+		}()
+	}
+	wg.Wait()
+
+	stats := wengine.LookupQueueStats()
+	assert.Equal(t, 2, stats.MaxLookups)
+	assert.Zero(t, stats.Running)
+	assert.Zero(t, stats.Queued)
+	assert.LessOrEqual(t, stats.MaxQueued, 6)
+	assert.Equal(t, int64(8*50*2), stats.Admitted)
+	assert.Zero(t, stats.Rejected)
+	assert.Zero(t, stats.TimedOut)
+
+	c, err := wengine.Config()
+	require.NoError(t, err)
+	assert.Equal(t, 2, c.LookupLimit.MaxConcurrentLookups)
+	require.NotNil(t, c.LookupLimit.QueueSize)
+	assert.Equal(t, 100, *c.LookupLimit.QueueSize)
+	assert.Equal(t, 60000, c.LookupLimit.QueueTimeout)
+}
+
+func TestWithMaxConcurrentLookups_Invalid(t *testing.T) {
+	invalid := map[string][]wurfl.Option{
+		"WithMaxConcurrentLookups": {wurfl.WithMaxConcurrentLookups(0)},
+		"WithLookupQueue":          {wurfl.WithMaxConcurrentLookups(1), wurfl.WithLookupQueue(1, -time.Second)},
+	}
+	for name, opts := range invalid {
+		_, err := wurfl.CreateWithOptions(fixtureWurflZip(), opts...)
+		var optErr *wurfl.OptionError
+		require.ErrorAs(t, err, &optErr, name)
+		assert.Equal(t, name, optErr.Option)
+		assert.ErrorIs(t, err, wurfl.ErrInvalidParameter, name)
+	}
+
+	// a queue without a limit is meaningless
+	_, err := wurfl.CreateWithOptions(fixtureWurflZip(), wurfl.WithLookupQueue(10, time.Second))
+	var optErr *wurfl.OptionError
+	require.ErrorAs(t, err, &optErr)
+	assert.Equal(t, "WithLookupQueue", optErr.Option)
+
+	// no limiter, no stats
+	wengine := fixtureCreateEngine(t)
+	defer wengine.Destroy()
+	assert.Equal(t, wurfl.LookupQueueStats{}, wengine.LookupQueueStats())
+}
+
+func TestConfig_LookupLimit(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"data_file": "/data/wurfl.zip",
+		"lookup_limit": {"max_concurrent_lookups": 16, "queue_size": 1000}
+	}`)
+	t.Setenv("WURFL_LOOKUP_QUEUE_TIMEOUT_MS", "250")
+
+	c, err := wurfl.LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, 16, c.LookupLimit.MaxConcurrentLookups)
+	require.NotNil(t, c.LookupLimit.QueueSize)
+	assert.Equal(t, 1000, *c.LookupLimit.QueueSize)
+	assert.Equal(t, 250, c.LookupLimit.QueueTimeout)
+	assert.NoError(t, c.Validate())
+
+	c.LookupLimit.MaxConcurrentLookups = 0
+	var cfgErr *wurfl.ConfigError
+	require.ErrorAs(t, c.Validate(), &cfgErr)
+	require.Len(t, cfgErr.Fields, 1)
+	assert.Equal(t, "lookup_limit.queue_size", cfgErr.Fields[0].Field)
+}
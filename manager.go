@@ -0,0 +1,174 @@
+package wurfl
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// EngineManager holds the engine used by a long running service and allows to replace it
+// with a freshly created one (new data, patches or options) without downtime.
+//
+// Lookups go through a Lease, or through the EngineManager Lookup* shortcuts that take
+// one for the duration of the call. When an engine is replaced by Swap, it keeps serving
+// the leases already taken; once the last lease is released it is drained: the Devices
+// obtained from it stay valid until their Destroy, after which the engine is destroyed.
+//
+//	m, err := wurfl.NewEngineManager(wengine)
+//	...
+//	next, err := wurfl.CreateWithOptions("/usr/share/wurfl/wurfl.zip", wurfl.WithCacheSize(200000))
+//	if err == nil {
+//		m.Swap(next)
+//	}
+//
+// An engine handed to an EngineManager is owned by it and must not be destroyed directly.
+type EngineManager struct {
+	current atomic.Pointer[managedEngine]
+	mu      sync.Mutex // serializes Swap and Close
+}
+
+// managedEngine is a *Wurfl with the count of leases taken on it, plus one held
+// by the EngineManager while it is the current engine
+type managedEngine struct {
+	w    *Wurfl
+	refs atomic.Int64
+}
+
+// tryAcquire takes a lease unless the engine is already draining
+func (me *managedEngine) tryAcquire() bool {
+	for {
+		n := me.refs.Load()
+		if n <= 0 {
+			return false
+		}
+		if me.refs.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// release drops a lease, draining the engine when it was the last one
+func (me *managedEngine) release() {
+	if me.refs.Add(-1) == 0 {
+		me.w.drain()
+	}
+}
+
+// Lease gives access to the engine that was current when it was taken. The engine is
+// guaranteed to stay alive until Release, even if it is replaced in the meantime.
+type Lease struct {
+	me   *managedEngine
+	once sync.Once
+}
+
+// Wurfl returns the leased engine
+func (l *Lease) Wurfl() *Wurfl {
+	return l.me.w
+}
+
+// Release gives the lease back. It is safe to call it more than once.
+func (l *Lease) Release() {
+	l.once.Do(l.me.release)
+}
+
+// NewEngineManager creates an EngineManager serving w
+func NewEngineManager(w *Wurfl) (*EngineManager, error) {
+	if w == nil || w.engine.Load() == nil {
+		return nil, checkHandleError(nil)
+	}
+	m := &EngineManager{}
+	m.current.Store(newManagedEngine(w))
+	return m, nil
+}
+
+func newManagedEngine(w *Wurfl) *managedEngine {
+	me := &managedEngine{w: w}
+	me.refs.Store(1)
+	return me
+}
+
+// Acquire returns a lease on the current engine. The caller must Release it.
+func (m *EngineManager) Acquire() (*Lease, error) {
+	for {
+		me := m.current.Load()
+		if me == nil {
+			return nil, ErrEngineClosed
+		}
+		if me.tryAcquire() {
+			return &Lease{me: me}, nil
+		}
+		// me has just been replaced by a concurrent Swap, load the new one
+	}
+}
+
+// Swap makes w the current engine. The previous one is drained and destroyed once all
+// its leases are released and all the Devices obtained from it are destroyed.
+func (m *EngineManager) Swap(w *Wurfl) error {
+	if w == nil || w.engine.Load() == nil {
+		return checkHandleError(nil)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old := m.current.Load()
+	if old == nil {
+		return ErrEngineClosed
+	}
+	if old.w == w {
+		return nil
+	}
+	m.current.Store(newManagedEngine(w))
+	old.release()
+	return nil
+}
+
+// Close stops serving lookups. The current engine is drained and destroyed like on Swap.
+func (m *EngineManager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if old := m.current.Swap(nil); old != nil {
+		old.release()
+	}
+}
+
+// LookupUserAgent : lookup a user agent on the current engine
+func (m *EngineManager) LookupUserAgent(ua string) (*Device, error) {
+	l, err := m.Acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+	return l.Wurfl().LookupUserAgent(ua)
+}
+
+// LookupDeviceID : lookup a device id on the current engine
+func (m *EngineManager) LookupDeviceID(DeviceID string) (*Device, error) {
+	l, err := m.Acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+	return l.Wurfl().LookupDeviceID(DeviceID)
+}
+
+// LookupRequest : lookup an http.Request on the current engine
+func (m *EngineManager) LookupRequest(r *http.Request) (*Device, error) {
+	l, err := m.Acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+	return l.Wurfl().LookupRequest(r)
+}
+
+// LookupWithImportantHeaderMap : lookup a map of important headers on the current engine
+func (m *EngineManager) LookupWithImportantHeaderMap(IHMap map[string]string) (*Device, error) {
+	l, err := m.Acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+	return l.Wurfl().LookupWithImportantHeaderMap(IHMap)
+}
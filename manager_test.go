@@ -0,0 +1,104 @@
+package wurfl_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	wurfl "github.com/WURFL/golang-wurfl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngineManager_Swap(t *testing.T) {
+	first := fixtureCreateEngine(t)
+	require.NotNil(t, first)
+
+	m, err := wurfl.NewEngineManager(first)
+	require.NoError(t, err)
+	defer m.Close()
+
+	lease, err := m.Acquire()
+	require.NoError(t, err)
+	assert.Same(t, first, lease.Wurfl())
+
+	oldDevice, err := m.LookupUserAgent("ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	require.NoError(t, err)
+
+	second, err := wurfl.CreateWithOptions(fixtureWurflZip(), wurfl.WithCacheSize(200000))
+	require.NoError(t, err)
+	require.NoError(t, m.Swap(second))
+
+	// new lookups use the new engine, while the lease still holds the old one
+	newLease, err := m.Acquire()
+	require.NoError(t, err)
+	assert.Same(t, second, newLease.Wurfl())
+	newLease.Release()
+
+	device, err := lease.Wurfl().LookupDeviceID("generic")
+	require.NoError(t, err)
+	device.Destroy()
+
+	// the old engine is drained once the last lease is released...
+	lease.Release()
+	lease.Release()
+	_, err = first.LookupDeviceID("generic")
+	assert.ErrorIs(t, err, wurfl.ErrInvalidHandle)
+
+	// ...but the devices obtained from it stay valid until their Destroy
+	deviceid, err := oldDevice.GetDeviceID()
+	assert.NoError(t, err)
+	assert.Equal(t, "apple_iphone_ver8_3_subuacfnetwork", deviceid)
+	oldDevice.Destroy()
+
+	assert.ErrorIs(t, m.Swap(nil), wurfl.ErrInvalidHandle)
+}
+
+func TestEngineManager_Close(t *testing.T) {
+	_, err := wurfl.NewEngineManager(nil)
+	assert.ErrorIs(t, err, wurfl.ErrInvalidHandle)
+
+	m, err := wurfl.NewEngineManager(fixtureCreateEngine(t))
+	require.NoError(t, err)
+	m.Close()
+	m.Close()
+
+	_, err = m.Acquire()
+	assert.ErrorIs(t, err, wurfl.ErrInvalidHandle)
+	_, err = m.LookupUserAgent("GolangWurflTestAgent/1.0")
+	assert.ErrorIs(t, err, wurfl.ErrInvalidHandle)
+}
+
+func TestEngineManager_ConcurrentSwap(t *testing.T) {
+	m, err := wurfl.NewEngineManager(fixtureCreateEngine(t))
+	require.NoError(t, err)
+	defer m.Close()
+
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				device, err := m.LookupUserAgent("ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+				if err != nil {
+					t.Errorf("lookup during swap failed: %v", err)
+					return
+				}
+				if _, err := device.GetStaticCap("brand_name"); err != nil {
+					t.Errorf("GetStaticCap during swap failed: %v", err)
+				}
+				device.Destroy()
+			}
+		}()
+	}
+
+	for i := 0; i < 3; i++ {
+		next, err := wurfl.CreateWithOptions(fixtureWurflZip(), wurfl.WithCacheSize(100000))
+		require.NoError(t, err)
+		require.NoError(t, m.Swap(next))
+	}
+	stop.Store(true)
+	wg.Wait()
+}
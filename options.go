@@ -0,0 +1,291 @@
+package wurfl
+
+import (
+	"strconv"
+)
+
+// Option configures a WURFL engine created with CreateWithOptions.
+// Options validate their input when applied: an invalid value makes
+// CreateWithOptions fail with an *OptionError naming the offending option.
+type Option func(*options) error
+
+// attrSetting is a single engine attribute set through WithAttr
+type attrSetting struct {
+	attr  int
+	value int
+}
+
+// options collects the settings of all the Options passed to CreateWithOptions
+type options struct {
+	patches   []patchSource
+	capFilter []string
+
+	stagingDir string // private directory holding data staged from memory, see datasource.go
+
+	cacheProvider    int
+	cacheExtraConfig string
+	cacheSizeSet     bool
+
+	logPath string
+	attrs   []attrSetting
+
+	updaterDataURL         string
+	updaterFrequency       int
+	updaterFrequencySet    bool
+	updaterConnTimeout     int
+	updaterTransferTimeout int
+	updaterTimeoutsSet     bool
+	updaterLogPath         string
+	updaterUserAgent       string
+	updaterStart           bool
+
+	usage         *CapabilityUsageRecorder // see usage.go
+	validateFiles bool                     // see validate.go
+	corpus        *Corpus                  // see canary.go
+	corpusPath    string
+	leaks         leakMode // see leak.go
+
+	fallbackDeviceID string        // see context.go
+	limit            limitSettings // see limiter.go
+	detectionCaps    []string      // see detection.go
+}
+
+func defaultOptions() *options {
+	return &options{
+		cacheProvider: WurflCacheProviderDefault,
+	}
+}
+
+// clone returns a copy of o that can be modified without affecting o
+func (o *options) clone() *options {
+	c := *o
+	c.patches = append([]patchSource(nil), o.patches...)
+	c.capFilter = append([]string(nil), o.capFilter...)
+	c.attrs = append([]attrSetting(nil), o.attrs...)
+	c.detectionCaps = append([]string(nil), o.detectionCaps...)
+	c.stagingDir = ""
+	return &c
+}
+
+// setAttr records an attribute value, replacing a previous value of the same attribute
+func (o *options) setAttr(attr int, value int) {
+	for i := range o.attrs {
+		if o.attrs[i].attr == attr {
+			o.attrs[i].value = value
+			return
+		}
+	}
+	o.attrs = append(o.attrs, attrSetting{attr: attr, value: value})
+}
+
+// WithPatches adds patch files to be loaded on top of the WURFL data file
+func WithPatches(patches ...string) Option {
+	return func(o *options) error {
+		for _, p := range patches {
+			if p == "" {
+				return &OptionError{Option: "WithPatches", Err: ErrInvalidParameter}
+			}
+		}
+		for _, p := range patches {
+			o.patches = append(o.patches, patchSource{path: p})
+		}
+		return nil
+	}
+}
+
+// WithCapabilityFilter restricts the engine to the listed capabilities. A bad capability
+// makes the creation fail with the libwurfl error; ValidateCapFilter reports all of them.
+//
+//	Note : Capability filtering is discouraged and will be deprecated in future versions
+func WithCapabilityFilter(caps ...string) Option {
+	return func(o *options) error {
+		for _, c := range caps {
+			if c == "" {
+				return &OptionError{Option: "WithCapabilityFilter", Err: ErrInvalidParameter}
+			}
+		}
+		o.capFilter = append(o.capFilter, caps...)
+		return nil
+	}
+}
+
+// WithCacheProvider selects the cache provider: WurflCacheProviderLru, WurflCacheProviderNone
+// or WurflCacheProviderDefault to keep the libwurfl default
+func WithCacheProvider(provider int) Option {
+	return func(o *options) error {
+		switch provider {
+		case WurflCacheProviderDefault, WurflCacheProviderNone, WurflCacheProviderLru, WurflCacheProviderDoubleLru:
+		default:
+			return &OptionError{Option: "WithCacheProvider", Err: ErrInvalidParameter}
+		}
+		o.cacheProvider = provider
+		return nil
+	}
+}
+
+// WithCacheSize sets the number of entries of the lookup cache. It implies the
+// LRU cache provider unless a different one has been selected with WithCacheProvider.
+func WithCacheSize(size int) Option {
+	return func(o *options) error {
+		if size <= 0 {
+			return &OptionError{Option: "WithCacheSize", Err: ErrInvalidCacheSize}
+		}
+		o.cacheExtraConfig = strconv.Itoa(size)
+		o.cacheSizeSet = true
+		return nil
+	}
+}
+
+// withCacheConfig passes provider and extra config to libwurfl unchanged, as Create always did
+func withCacheConfig(provider int, extraConfig string) Option {
+	return func(o *options) error {
+		o.cacheProvider = provider
+		o.cacheExtraConfig = extraConfig
+		return nil
+	}
+}
+
+// WithLogPath sets the path of the main libwurfl log file. It is applied before
+// wurfl_load, so that the load itself is logged.
+func WithLogPath(logFile string) Option {
+	return func(o *options) error {
+		if logFile == "" {
+			return &OptionError{Option: "WithLogPath", Err: ErrInvalidParameter}
+		}
+		o.logPath = logFile
+		return nil
+	}
+}
+
+// WithAttr sets an engine attribute (ie: WurflAttrCapabilityFallbackCache) right after
+// the engine has been loaded. It can be passed more than once.
+func WithAttr(attr int, value int) Option {
+	return func(o *options) error {
+		if err := Attr(attr).Validate(AttrValue(value)); err != nil {
+			return &OptionError{Option: "WithAttr", Err: err}
+		}
+		o.attrs = append(o.attrs, attrSetting{attr: attr, value: value})
+		return nil
+	}
+}
+
+// WithUpdaterDataURL sets your scientiamobile WURFL Snapshot URL
+func WithUpdaterDataURL(dataURL string) Option {
+	return func(o *options) error {
+		if dataURL == "" {
+			return &OptionError{Option: "WithUpdaterDataURL", Err: ErrUpdaterInvalidDataURL}
+		}
+		o.updaterDataURL = dataURL
+		return nil
+	}
+}
+
+// WithUpdaterFrequency sets the frequency of update checks (WurflUpdaterFrequencyDaily or WurflUpdaterFrequencyWeekly)
+func WithUpdaterFrequency(frequency int) Option {
+	return func(o *options) error {
+		switch frequency {
+		case WurflUpdaterFrequencyDaily, WurflUpdaterFrequencyWeekly:
+		default:
+			return &OptionError{Option: "WithUpdaterFrequency", Err: ErrInvalidParameter}
+		}
+		o.updaterFrequency = frequency
+		o.updaterFrequencySet = true
+		return nil
+	}
+}
+
+// WithUpdaterTimeouts sets connection and data transfer timeouts (in millisecs) for the
+// updater http call. 0 for no timeout, -1 for defaults
+func WithUpdaterTimeouts(connectionTimeout int, dataTransferTimeout int) Option {
+	return func(o *options) error {
+		if connectionTimeout < -1 || dataTransferTimeout < -1 {
+			return &OptionError{Option: "WithUpdaterTimeouts", Err: ErrInvalidParameter}
+		}
+		o.updaterConnTimeout = connectionTimeout
+		o.updaterTransferTimeout = dataTransferTimeout
+		o.updaterTimeoutsSet = true
+		return nil
+	}
+}
+
+// WithUpdaterLogPath sets the path of the updater log file
+func WithUpdaterLogPath(logFile string) Option {
+	return func(o *options) error {
+		if logFile == "" {
+			return &OptionError{Option: "WithUpdaterLogPath", Err: ErrInvalidParameter}
+		}
+		o.updaterLogPath = logFile
+		return nil
+	}
+}
+
+// WithUpdaterUserAgent sets the UserAgent used in calling the WURFL Snapshot server (needs libwurfl 1.13.0.0 or above)
+func WithUpdaterUserAgent(userAgent string) Option {
+	return func(o *options) error {
+		if userAgent == "" {
+			return &OptionError{Option: "WithUpdaterUserAgent", Err: ErrUpdaterInvalidUseragent}
+		}
+		if err := requireFeature(FeatureUpdaterUserAgent); err != nil {
+			return &OptionError{Option: "WithUpdaterUserAgent", Err: err}
+		}
+		o.updaterUserAgent = userAgent
+		return nil
+	}
+}
+
+// WithUpdaterStart starts the updater thread once the engine has been loaded.
+// It requires WithUpdaterDataURL.
+func WithUpdaterStart() Option {
+	return func(o *options) error {
+		o.updaterStart = true
+		return nil
+	}
+}
+
+// validate checks the consistency between options, after all of them have been applied
+func (o *options) validate() error {
+	if o.cacheSizeSet {
+		switch o.cacheProvider {
+		case WurflCacheProviderNone:
+			return &OptionError{Option: "WithCacheSize", Err: ErrInvalidParameter}
+		case WurflCacheProviderDefault:
+			o.cacheProvider = WurflCacheProviderLru
+		}
+	}
+	if o.updaterStart && o.updaterDataURL == "" {
+		return &OptionError{Option: "WithUpdaterStart", Err: ErrUpdaterInvalidDataURL}
+	}
+	if o.limit.queueSet && o.limit.maxLookups == 0 {
+		return &OptionError{Option: "WithLookupQueue", Err: ErrInvalidParameter}
+	}
+	return nil
+}
+
+// CreateWithOptions creates the wurfl engine from the wurfl.xml/zip file at path.
+// Options are applied in the order required by libwurfl: cache provider, log path,
+// patches and capability filter before wurfl_load; attributes and updater settings after it.
+//
+//	wengine, err := wurfl.CreateWithOptions("/usr/share/wurfl/wurfl.zip",
+//		wurfl.WithCacheSize(100000),
+//		wurfl.WithAttr(wurfl.WurflAttrCapabilityFallbackCache, wurfl.WurflAttrCapabilityFallbackCacheLimited))
+func CreateWithOptions(path string, opts ...Option) (*Wurfl, error) {
+	o, err := applyOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return create(path, o)
+}
+
+// applyOptions applies opts over the defaults and validates the result
+func applyOptions(opts []Option) (*options, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
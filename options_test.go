@@ -0,0 +1,129 @@
+package wurfl_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	wurfl "github.com/WURFL/golang-wurfl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fixtureWurflZip returns the path of the wurfl.zip installed with libwurfl
+func fixtureWurflZip() string {
+	if _, err := os.Stat("/usr/local/share/wurfl/wurfl.zip"); err == nil {
+		// macosx rootless
+		return "/usr/local/share/wurfl/wurfl.zip"
+	}
+	// all other systems (TODO windows)
+	return "/usr/share/wurfl/wurfl.zip"
+}
+
+func TestCreateWithOptions(t *testing.T) {
+	wengine, err := wurfl.CreateWithOptions(fixtureWurflZip(),
+		wurfl.WithCacheSize(100000),
+		wurfl.WithAttr(wurfl.WurflAttrCapabilityFallbackCache, wurfl.WurflAttrCapabilityFallbackCacheLimited))
+	require.NoError(t, err)
+	defer wengine.Destroy()
+
+	attrValue, err := wengine.GetAttr(wurfl.WurflAttrCapabilityFallbackCache)
+	assert.NoError(t, err)
+	assert.Equal(t, wurfl.WurflAttrCapabilityFallbackCacheLimited, attrValue)
+
+	device, err := wengine.LookupUserAgent("ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	require.NoError(t, err)
+	defer device.Destroy()
+
+	deviceid, err := device.GetDeviceID()
+	assert.NoError(t, err)
+	assert.Equal(t, "apple_iphone_ver8_3_subuacfnetwork", deviceid)
+}
+
+func TestCreateWithOptions_FileNotFound(t *testing.T) {
+	_, err := wurfl.CreateWithOptions("/nodir/wurfl.zip", wurfl.WithCacheSize(100000))
+	assert.ErrorIs(t, err, wurfl.ErrFileNotFound)
+}
+
+func TestCreateWithOptions_InvalidOptions(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []wurfl.Option
+		wantOption string
+		wantErr    error
+	}{
+		{
+			name:       "zero cache size",
+			opts:       []wurfl.Option{wurfl.WithCacheSize(0)},
+			wantOption: "WithCacheSize",
+			wantErr:    wurfl.ErrInvalidCacheSize,
+		},
+		{
+			name:       "cache size without cache",
+			opts:       []wurfl.Option{wurfl.WithCacheSize(100), wurfl.WithCacheProvider(wurfl.WurflCacheProviderNone)},
+			wantOption: "WithCacheSize",
+			wantErr:    wurfl.ErrInvalidParameter,
+		},
+		{
+			name:       "unknown cache provider",
+			opts:       []wurfl.Option{wurfl.WithCacheProvider(42)},
+			wantOption: "WithCacheProvider",
+			wantErr:    wurfl.ErrInvalidParameter,
+		},
+		{
+			name:       "empty patch",
+			opts:       []wurfl.Option{wurfl.WithPatches("")},
+			wantOption: "WithPatches",
+			wantErr:    wurfl.ErrInvalidParameter,
+		},
+		{
+			name:       "empty capability",
+			opts:       []wurfl.Option{wurfl.WithCapabilityFilter("brand_name", "")},
+			wantOption: "WithCapabilityFilter",
+			wantErr:    wurfl.ErrInvalidParameter,
+		},
+		{
+			name:       "unknown attribute",
+			opts:       []wurfl.Option{wurfl.WithAttr(44, 10)},
+			wantOption: "WithAttr",
+			wantErr:    wurfl.ErrInvalidParameter,
+		},
+		{
+			name:       "invalid fallback cache value",
+			opts:       []wurfl.Option{wurfl.WithAttr(wurfl.WurflAttrCapabilityFallbackCache, 44)},
+			wantOption: "WithAttr",
+			wantErr:    wurfl.ErrInvalidParameter,
+		},
+		{
+			name:       "invalid updater frequency",
+			opts:       []wurfl.Option{wurfl.WithUpdaterFrequency(44)},
+			wantOption: "WithUpdaterFrequency",
+			wantErr:    wurfl.ErrInvalidParameter,
+		},
+		{
+			name:       "invalid updater timeouts",
+			opts:       []wurfl.Option{wurfl.WithUpdaterTimeouts(-2, 0)},
+			wantOption: "WithUpdaterTimeouts",
+			wantErr:    wurfl.ErrInvalidParameter,
+		},
+		{
+			name:       "updater start without url",
+			opts:       []wurfl.Option{wurfl.WithUpdaterStart()},
+			wantOption: "WithUpdaterStart",
+			wantErr:    wurfl.ErrUpdaterInvalidDataURL,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wengine, err := wurfl.CreateWithOptions(fixtureWurflZip(), tt.opts...)
+			require.Error(t, err)
+			assert.Nil(t, wengine)
+			assert.ErrorIs(t, err, tt.wantErr)
+
+			var optErr *wurfl.OptionError
+			require.True(t, errors.As(err, &optErr))
+			assert.Equal(t, tt.wantOption, optErr.Option)
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package wurfl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ShutdownError is returned by Shutdown when the context ends before every lookup and
+// Device using the engine is done
+type ShutdownError struct {
+	Lookups int   // lookups and other calls still running
+	Devices int   // Devices not destroyed yet
+	Err     error // the context error
+}
+
+// Error returns what was still outstanding when the context ended.
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("wurfl: shutdown interrupted with %d lookups in flight and %d devices not destroyed: %v",
+		e.Lookups, e.Devices, e.Err)
+}
+
+// Unwrap returns the context error, allowing errors.Is to work.
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
+}
+
+// Shutdown gracefully stops the engine: it stops the updater, makes every new lookup and
+// method call fail, then waits for the lookups in flight to return and for the Devices
+// still in use (including those obtained before a Reload) to be destroyed. The engine is
+// destroyed as soon as nothing uses it anymore.
+//
+// If ctx ends first, Shutdown returns a *ShutdownError reporting what is still outstanding.
+// The engine is then destroyed when the last outstanding Device is, unless Destroy is called
+// to free it right away. Calling Shutdown again waits for the same outstanding work.
+func (w *Wurfl) Shutdown(ctx context.Context) error {
+	w.mu.Lock()
+	var updaterErr error
+	engines := w.retired
+	if e := w.engine.Swap(nil); e != nil {
+		if w.opts.updaterStart {
+			updaterErr = e.updaterStop()
+			w.opts.updaterStart = false
+		}
+		w.Wurfl = nil
+		w.untrack()
+		engines = append(engines, e)
+		// drop the Wurfl own reference, the lookups and Devices keep theirs
+		e.unref()
+	}
+	w.retired = nil
+	w.mu.Unlock()
+
+	var pending []*engine
+wait:
+	for i, e := range engines {
+		select {
+		case <-e.freed:
+		case <-ctx.Done():
+			pending = engines[i:]
+			break wait
+		}
+	}
+
+	// keep the outstanding engines so that Destroy can still force them out
+	shutdownErr := &ShutdownError{Err: ctx.Err()}
+	w.mu.Lock()
+	for _, e := range pending {
+		select {
+		case <-e.freed:
+			continue
+		default:
+		}
+		devices := int(e.devices.Load())
+		shutdownErr.Devices += devices
+		shutdownErr.Lookups += int(e.calls.Load())
+		w.retired = append(w.retired, e)
+	}
+	outstanding := len(w.retired) != 0
+	w.mu.Unlock()
+
+	switch {
+	case !outstanding:
+		return updaterErr
+	case updaterErr != nil:
+		return errors.Join(updaterErr, shutdownErr)
+	default:
+		return shutdownErr
+	}
+}
@@ -0,0 +1,82 @@
+package wurfl_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	wurfl "github.com/WURFL/golang-wurfl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWurfl_Shutdown(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+
+	require.NoError(t, wengine.Shutdown(context.Background()))
+
+	_, err := wengine.LookupUserAgent("ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	assert.Error(t, err)
+	_, err = wengine.Config()
+	assert.Error(t, err)
+
+	// shutting down twice, or destroying afterwards, is harmless
+	assert.NoError(t, wengine.Shutdown(context.Background()))
+	wengine.Destroy()
+}
+
+func TestWurfl_Shutdown_WaitsForDevices(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+
+	device, err := wengine.LookupUserAgent("ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		time.Sleep(50 * time.Millisecond)
+		// the device stays usable while Shutdown waits for it
+		id, err := device.GetDeviceID()
+		assert.NoError(t, err)
+		assert.Equal(t, "apple_iphone_ver8_3_subuacfnetwork", id)
+		device.Destroy()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.NoError(t, wengine.Shutdown(ctx))
+	wg.Wait()
+}
+
+func TestWurfl_Shutdown_Deadline(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+
+	device, err := wengine.LookupUserAgent("ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	require.NoError(t, err)
+	// a Device from data replaced by Reload is waited for too
+	require.NoError(t, wengine.Reload(fixtureWurflZip(), nil))
+	other, err := wengine.LookupUserAgent("ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = wengine.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	var shutdownErr *wurfl.ShutdownError
+	require.True(t, errors.As(err, &shutdownErr))
+	assert.Equal(t, 2, shutdownErr.Devices)
+	assert.Equal(t, 0, shutdownErr.Lookups)
+
+	// no new lookups, but the outstanding devices still work
+	_, err = wengine.LookupUserAgent("ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	assert.Error(t, err)
+	_, err = other.GetDeviceID()
+	assert.NoError(t, err)
+
+	device.Destroy()
+	other.Destroy()
+	assert.NoError(t, wengine.Shutdown(context.Background()))
+}
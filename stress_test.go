@@ -0,0 +1,161 @@
+package wurfl_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	wurfl "github.com/WURFL/golang-wurfl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestWurfl_ConcurrencyStress mixes lookups with SetAttr and Reloads, with and without a
+// patch, on the same engine. When SM_UPDATER_DATA_URL is set, the libwurfl updater also
+// reloads the data inside the live handle, under the Go caches. It is meant to be run
+// with -race.
+func TestWurfl_ConcurrencyStress(t *testing.T) {
+	iterations := 200
+	if testing.Short() {
+		iterations = 20
+	}
+
+	// the updater replaces the root file: work on a copy
+	root := fixtureWurflZip()
+	updaterURL := os.Getenv("SM_UPDATER_DATA_URL")
+	if updaterURL != "" {
+		root = filepath.Join(t.TempDir(), "wurfl.zip")
+		require.NoError(t, copyFile(fixtureWurflZip(), root))
+	}
+
+	wengine, err := wurfl.CreateWithOptions(root)
+	require.NoError(t, err)
+	defer wengine.Destroy()
+	if updaterURL != "" {
+		require.NoError(t, wengine.SetUpdaterDataURL(updaterURL))
+	}
+
+	patch := filepath.Join(t.TempDir(), "patch.xml")
+	require.NoError(t, os.WriteFile(patch, []byte(testPatch), 0o600))
+
+	ua := "ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0"
+	headers := map[string]string{
+		"User-Agent":         ua,
+		"Sec-CH-UA":          `"Chromium";v="118", "Google Chrome";v="118", "Not=A?Brand";v="99"`,
+		"Sec-CH-UA-Platform": `"Android"`,
+	}
+
+	var lookups sync.WaitGroup
+	lookup := func(f func() error) {
+		lookups.Add(1)
+		go func() {
+			defer lookups.Done()
+			for i := 0; i < iterations; i++ {
+				if err := f(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	readDevice := func(device *wurfl.Device, err error) error {
+		if err != nil {
+			return err
+		}
+		defer device.Destroy()
+		if _, err := device.GetDeviceID(); err != nil {
+			return err
+		}
+		if _, err := device.GetStaticCaps([]string{"brand_name", "model_name"}); err != nil {
+			return err
+		}
+		_, err = device.GetVirtualCap("form_factor")
+		return err
+	}
+
+	for g := 0; g < 2; g++ {
+		lookup(func() error {
+			return readDevice(wengine.LookupUserAgent(ua))
+		})
+		lookup(func() error {
+			return readDevice(wengine.LookupWithImportantHeaderMap(headers))
+		})
+		lookup(func() error {
+			req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+			for name, value := range headers {
+				req.Header.Set(name, value)
+			}
+			if _, err := wengine.GetHeaderQuality(req); err != nil {
+				return err
+			}
+			return readDevice(wengine.LookupRequest(req))
+		})
+		lookup(func() error {
+			names := wengine.GetImportantHeaderNames()
+			if len(names) == 0 {
+				return wurfl.ErrInvalidParameter
+			}
+			// the copy belongs to the caller
+			names[0] = "X-Overwritten"
+			return nil
+		})
+	}
+
+	// writers run until the lookups are done
+	done := make(chan struct{})
+	var writers sync.WaitGroup
+	write := func(f func(i int)) {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				f(i)
+			}
+		}()
+	}
+	write(func(i int) {
+		assert.NoError(t, wengine.SetAttr(wurfl.WurflAttrExtraHeadersExperimental, i%2))
+	})
+	write(func(i int) {
+		assert.NoError(t, wengine.Reload(root, nil))
+	})
+	write(func(i int) {
+		// the patched data has one more device than the data of the writer above
+		assert.NoError(t, wengine.Reload(root, []string{patch}))
+		_, err := wengine.Config()
+		assert.NoError(t, err)
+	})
+	if updaterURL != "" {
+		// a few runs only: each one checks the snapshot server, and downloads the data
+		// the first time
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			for i := 0; i < 3; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				assert.NoError(t, wengine.UpdaterRunonce())
+			}
+		}()
+	} else {
+		t.Log("SM_UPDATER_DATA_URL environment var not set: the updater is not exercised")
+	}
+
+	lookups.Wait()
+	close(done)
+	writers.Wait()
+
+	names := wengine.GetImportantHeaderNames()
+	require.NotEmpty(t, names)
+	assert.NotContains(t, names, "X-Overwritten")
+}
@@ -0,0 +1,180 @@
+package wurfl
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// CapabilityUsageRecorder counts the capabilities read through the Device getters, to find
+// out which ones an application really uses and build a capability filter from them.
+// Recording is opt-in: pass the recorder to WithCapabilityUsageRecorder or
+// Wurfl.SetCapabilityUsageRecorder. A recorder can be shared by several engines.
+//
+//	usage := wurfl.NewCapabilityUsageRecorder()
+//	wengine.SetCapabilityUsageRecorder(usage)
+//	... run the application for a while ...
+//	data, _ := json.Marshal(usage.Report())
+type CapabilityUsageRecorder struct {
+	static    sync.Map // capability name -> *atomic.Int64
+	virtual   sync.Map // virtual capability name -> *atomic.Int64
+	mandatory sync.Map // mandatory capability name -> struct{}, see recordStatic
+}
+
+// NewCapabilityUsageRecorder creates an empty recorder
+func NewCapabilityUsageRecorder() *CapabilityUsageRecorder {
+	return &CapabilityUsageRecorder{}
+}
+
+// count increments the counter of name in m, and reports whether it is the first one
+func count(m *sync.Map, name string) bool {
+	c, loaded := m.Load(name)
+	if !loaded {
+		c, loaded = m.LoadOrStore(name, new(atomic.Int64))
+	}
+	c.(*atomic.Int64).Add(1)
+	return !loaded
+}
+
+// counts returns a copy of the counters in m
+func counts(m *sync.Map) map[string]int64 {
+	result := make(map[string]int64)
+	m.Range(func(k, v any) bool {
+		result[k.(string)] = v.(*atomic.Int64).Load()
+		return true
+	})
+	return result
+}
+
+// recordStatic counts name, remembering whether it is a mandatory capability of the
+// engine: those are loaded whatever the filter, and left out of RecommendedCapFilter.
+func (r *CapabilityUsageRecorder) recordStatic(name string, mandatory bool) {
+	if count(&r.static, name) && mandatory {
+		r.mandatory.Store(name, struct{}{})
+	}
+}
+
+func (r *CapabilityUsageRecorder) recordVirtual(name string) {
+	count(&r.virtual, name)
+}
+
+// recordStatic records a read of the static capability name from d, unless d is not
+// recording or name is not a static capability of its engine: unknown names would end up
+// in the recommended capability filter, and grow the counters without bound.
+func (d *Device) recordStatic(name string) {
+	if d.usage == nil {
+		return
+	}
+	if _, known := d.capsCStringcache[name]; known && !d.engine.virtualCaps[name] {
+		d.usage.recordStatic(name, d.engine.mandatoryCaps[name])
+	}
+}
+
+// recordVirtual records a read of the virtual capability name from d, unless d is not
+// recording or name is not a capability of its engine, see recordStatic
+func (d *Device) recordVirtual(name string) {
+	if d.usage == nil {
+		return
+	}
+	if _, known := d.capsCStringcache[name]; known {
+		d.usage.recordVirtual(name)
+	}
+}
+
+// StaticCounts returns how many times each static capability has been read
+func (r *CapabilityUsageRecorder) StaticCounts() map[string]int64 {
+	return counts(&r.static)
+}
+
+// VirtualCounts returns how many times each virtual capability has been read
+func (r *CapabilityUsageRecorder) VirtualCounts() map[string]int64 {
+	return counts(&r.virtual)
+}
+
+// Reset clears all the counters
+func (r *CapabilityUsageRecorder) Reset() {
+	for _, m := range []*sync.Map{&r.static, &r.virtual, &r.mandatory} {
+		m.Range(func(k, _ any) bool {
+			m.Delete(k)
+			return true
+		})
+	}
+}
+
+// RecommendedCapFilter returns the sorted list of static capabilities to pass as CapFilter
+// to Create (or WithCapabilityFilter): the ones read so far, except the mandatory ones
+// (see Wurfl.GetMandatoryCaps) that ValidateCapFilter reports as redundant. libwurfl
+// always loads the mandatory capabilities, which the virtual capabilities are computed
+// from: reading a virtual capability adds nothing to the filter.
+func (r *CapabilityUsageRecorder) RecommendedCapFilter() []string {
+	filter := []string{}
+	r.static.Range(func(k, _ any) bool {
+		if _, mandatory := r.mandatory.Load(k); !mandatory {
+			filter = append(filter, k.(string))
+		}
+		return true
+	})
+	sort.Strings(filter)
+	return filter
+}
+
+// CapabilityUsageReport is the JSON export of a CapabilityUsageRecorder. Its
+// capability_filter field has the same name as in Config, so it can be copied
+// into a configuration file as is.
+type CapabilityUsageReport struct {
+	StaticCapabilities  map[string]int64 `json:"static_capabilities"`
+	VirtualCapabilities map[string]int64 `json:"virtual_capabilities"`
+	CapabilityFilter    []string         `json:"capability_filter"`
+}
+
+// Report returns the counters and the recommended capability filter
+func (r *CapabilityUsageRecorder) Report() *CapabilityUsageReport {
+	return &CapabilityUsageReport{
+		StaticCapabilities:  r.StaticCounts(),
+		VirtualCapabilities: r.VirtualCounts(),
+		CapabilityFilter:    r.RecommendedCapFilter(),
+	}
+}
+
+// LoadCapabilityFilter reads the capability filter from a JSON CapabilityUsageReport file
+func LoadCapabilityFilter(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fsError(err)
+	}
+	var report CapabilityUsageReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, err
+	}
+	return report.CapabilityFilter, nil
+}
+
+// WithCapabilityUsageRecorder records the capabilities read from the Devices of the engine into r
+func WithCapabilityUsageRecorder(r *CapabilityUsageRecorder) Option {
+	return func(o *options) error {
+		if r == nil {
+			return &OptionError{Option: "WithCapabilityUsageRecorder", Err: ErrInvalidParameter}
+		}
+		o.usage = r
+		return nil
+	}
+}
+
+// SetCapabilityUsageRecorder starts recording the capabilities read from the Devices looked
+// up from now on into r. A nil r stops recording.
+func (w *Wurfl) SetCapabilityUsageRecorder(r *CapabilityUsageRecorder) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	e := w.acquire()
+	if e == nil {
+		return ErrEngineClosed
+	}
+	defer e.release()
+
+	e.usage.Store(r)
+	w.opts.usage = r
+	return nil
+}
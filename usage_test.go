@@ -0,0 +1,119 @@
+package wurfl_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	wurfl "github.com/WURFL/golang-wurfl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapabilityUsageRecorder(t *testing.T) {
+	usage := wurfl.NewCapabilityUsageRecorder()
+
+	wengine, err := wurfl.CreateWithOptions(fixtureWurflZip(), wurfl.WithCapabilityUsageRecorder(usage))
+	require.NoError(t, err)
+	defer wengine.Destroy()
+
+	device, err := wengine.LookupUserAgent("ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	require.NoError(t, err)
+	defer device.Destroy()
+
+	_, err = device.GetStaticCap("brand_name")
+	assert.NoError(t, err)
+	_, err = device.GetStaticCaps([]string{"brand_name", "model_name"})
+	assert.NoError(t, err)
+	_, err = device.GetVirtualCap("is_android")
+	assert.NoError(t, err)
+	_, err = device.GetVirtualCaps([]string{"form_factor"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, map[string]int64{"brand_name": 2, "model_name": 1}, usage.StaticCounts())
+	assert.Equal(t, map[string]int64{"is_android": 1, "form_factor": 1}, usage.VirtualCounts())
+
+	// the mandatory capabilities are always loaded, the virtual ones add nothing
+	mandatory := wengine.GetMandatoryCaps()
+	expected := []string{}
+	for _, name := range []string{"brand_name", "model_name"} {
+		if !slices.Contains(mandatory, name) {
+			expected = append(expected, name)
+		}
+	}
+	filter := usage.RecommendedCapFilter()
+	assert.Equal(t, expected, filter)
+
+	// the recommended filter passes the validator
+	assert.NoError(t, wurfl.ValidateCapFilter(fixtureWurflZip(), filter))
+
+	// the exported report can be fed back into Create
+	data, err := json.Marshal(usage.Report())
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "usage.json")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	loaded, err := wurfl.LoadCapabilityFilter(path)
+	require.NoError(t, err)
+	assert.Equal(t, filter, loaded)
+
+	usage.Reset()
+	assert.Empty(t, usage.StaticCounts())
+	assert.Empty(t, usage.RecommendedCapFilter())
+}
+
+func TestCapabilityUsageRecorder_UnknownNames(t *testing.T) {
+	usage := wurfl.NewCapabilityUsageRecorder()
+
+	wengine, err := wurfl.CreateWithOptions(fixtureWurflZip(), wurfl.WithCapabilityUsageRecorder(usage))
+	require.NoError(t, err)
+	defer wengine.Destroy()
+
+	device, err := wengine.LookupUserAgent("ArtDeviant/3.0.2 CFNetwork/711.3.18 Darwin/14.0.0")
+	require.NoError(t, err)
+	defer device.Destroy()
+
+	// names that are not capabilities, or not of the right kind, are not recorded
+	_, err = device.GetStaticCap("not_a_capability")
+	assert.Error(t, err)
+	_, err = device.GetStaticCaps([]string{"brand_name", "not_a_capability"})
+	assert.Error(t, err)
+	_, err = device.GetStaticCap("is_android")
+	assert.Error(t, err)
+	_, err = device.GetVirtualCap("not_a_virtual_capability")
+	assert.Error(t, err)
+
+	assert.Equal(t, map[string]int64{"brand_name": 1}, usage.StaticCounts())
+	assert.Empty(t, usage.VirtualCounts())
+	assert.Subset(t, []string{"brand_name"}, usage.RecommendedCapFilter())
+}
+
+func TestWurfl_SetCapabilityUsageRecorder(t *testing.T) {
+	wengine := fixtureCreateEngine(t)
+	require.NotNil(t, wengine)
+	defer wengine.Destroy()
+
+	// recording is off by default
+	device, err := wengine.LookupDeviceID("generic")
+	require.NoError(t, err)
+	usage := wurfl.NewCapabilityUsageRecorder()
+	require.NoError(t, wengine.SetCapabilityUsageRecorder(usage))
+	device.GetStaticCap("brand_name")
+	device.Destroy()
+	assert.Empty(t, usage.StaticCounts())
+
+	device, err = wengine.LookupDeviceID("generic")
+	require.NoError(t, err)
+	device.GetStaticCap("brand_name")
+	device.Destroy()
+	assert.Equal(t, int64(1), usage.StaticCounts()["brand_name"])
+
+	// the recorder survives a reload
+	require.NoError(t, wengine.Reload(fixtureWurflZip(), nil))
+	device, err = wengine.LookupDeviceID("generic")
+	require.NoError(t, err)
+	device.GetVirtualCap("form_factor")
+	device.Destroy()
+	assert.Equal(t, int64(1), usage.VirtualCounts()["form_factor"])
+}
@@ -0,0 +1,215 @@
+package wurfl
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// DataFileError reports a problem found by ValidateDataFile or ValidatePatchFile, with the
+// position in the XML when known. Err is one of the sentinels libwurfl would return for
+// the same file, ie: ErrUnexpectedEndOfFile, ErrXMLParse or ErrXMLConsistency.
+type DataFileError struct {
+	Path   string
+	Entry  string // name of the xml file inside a zip archive, if any
+	Line   int    // 1-based, 0 if unknown
+	Column int    // 1-based, 0 if unknown
+	Msg    string
+	Err    error
+}
+
+// Error returns the file and position followed by the problem description.
+func (e *DataFileError) Error() string {
+	where := e.Path
+	if e.Entry != "" {
+		where += "!" + e.Entry
+	}
+	if e.Line > 0 {
+		where += fmt.Sprintf(":%d:%d", e.Line, e.Column)
+	}
+	return "wurfl: " + where + ": " + e.Msg
+}
+
+// Unwrap returns the sentinel error, allowing errors.Is to work.
+func (e *DataFileError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateDataFile checks a WURFL data file (zip, gz or xml) in pure Go, without loading it
+// in libwurfl: the zip or gzip container and its checksums, the XML well-formedness and the
+// <wurfl> root element. Problems are returned as a *DataFileError with the line and column.
+func ValidateDataFile(path string) error {
+	return validateFile(path, "wurfl")
+}
+
+// ValidatePatchFile checks a WURFL patch file like ValidateDataFile does, expecting
+// a <wurfl_patch> root element.
+func ValidatePatchFile(path string) error {
+	return validateFile(path, "wurfl_patch")
+}
+
+// WithDataFileValidation runs ValidateDataFile on the data file and ValidatePatchFile on
+// every patch before loading them, to get precise errors on broken files. It reads every
+// file once more, which makes the engine creation slower.
+func WithDataFileValidation() Option {
+	return func(o *options) error {
+		o.validateFiles = true
+		return nil
+	}
+}
+
+func validateFile(path, root string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fsError(err)
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return fsError(err)
+	}
+	return validateData(f, st.Size(), path, root)
+}
+
+// validateData validates the size bytes of data read from ra, reported as path
+func validateData(ra io.ReaderAt, size int64, path, root string) error {
+	br := bufio.NewReader(io.NewSectionReader(ra, 0, size))
+	head, _ := br.Peek(512)
+	format, err := detectDataFormat(head)
+	if err != nil {
+		if len(head) == 0 {
+			return &DataFileError{Path: path, Msg: "empty file", Err: ErrUnexpectedEndOfFile}
+		}
+		return &DataFileError{Path: path, Msg: "unknown file format, expected zip, gz or xml", Err: err}
+	}
+
+	switch format {
+	case ".zip":
+		return validateZip(ra, size, path, root)
+	case ".xml.gz":
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return &DataFileError{Path: path, Msg: "invalid gzip header: " + err.Error(), Err: ErrUnexpectedEndOfFile}
+		}
+		defer gz.Close()
+		return validateXML(gz, path, "", root)
+	default:
+		return validateXML(br, path, "", root)
+	}
+}
+
+// validateZip checks the archive holds a single xml file and validates it; the entry CRC is
+// checked by archive/zip when the entry has been read to the end.
+func validateZip(ra io.ReaderAt, size int64, path, root string) error {
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		// a truncated archive loses its end of central directory record
+		if !hasEndOfCentralDirectory(ra, size) {
+			return &DataFileError{Path: path, Msg: "zip archive is truncated: " + err.Error(), Err: ErrUnexpectedEndOfFile}
+		}
+		return &DataFileError{Path: path, Msg: "invalid zip archive: " + err.Error(), Err: ErrNotZipFile}
+	}
+
+	var entry *zip.File
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+		if entry != nil {
+			return &DataFileError{Path: path, Msg: "zip archive holds more than one file", Err: ErrNotZipFile}
+		}
+		entry = zf
+	}
+	if entry == nil {
+		return &DataFileError{Path: path, Msg: "empty zip archive", Err: ErrUnexpectedEndOfFile}
+	}
+
+	if offset, err := entry.DataOffset(); err == nil && uint64(offset)+entry.CompressedSize64 > uint64(size) {
+		return &DataFileError{Path: path, Entry: entry.Name, Msg: "zip archive is truncated", Err: ErrUnexpectedEndOfFile}
+	}
+
+	rc, err := entry.Open()
+	if err != nil {
+		return &DataFileError{Path: path, Entry: entry.Name, Msg: "cannot open zip entry: " + err.Error(), Err: ErrNotZipFile}
+	}
+	defer rc.Close()
+	return validateXML(rc, path, entry.Name, root)
+}
+
+// hasEndOfCentralDirectory reports whether the end of central directory record of a zip
+// archive is found in its last bytes, after which only the archive comment may follow
+func hasEndOfCentralDirectory(ra io.ReaderAt, size int64) bool {
+	const maxTail = 22 + 65535 // record size + longest comment
+	tail := min(size, maxTail)
+	buf := make([]byte, tail)
+	if n, _ := ra.ReadAt(buf, size-tail); int64(n) < tail {
+		return false
+	}
+	return bytes.Contains(buf, []byte("PK\x05\x06"))
+}
+
+// validateXML streams the whole document, checking it is well-formed and has the expected root
+func validateXML(r io.Reader, path, entry, root string) error {
+	dec := xml.NewDecoder(r)
+	// the WURFL data file is ISO-8859-1 or UTF-8: only well-formedness matters here
+	dec.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) { return input, nil }
+
+	fail := func(msg string, sentinel error) error {
+		line, col := dec.InputPos()
+		return &DataFileError{Path: path, Entry: entry, Line: line, Column: col, Msg: msg, Err: sentinel}
+	}
+
+	// Token checks that start and end elements match
+	depth := 0
+	seenRoot := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var syntaxErr *xml.SyntaxError
+			switch {
+			case errors.Is(err, zip.ErrChecksum), errors.Is(err, gzip.ErrChecksum):
+				return fail("checksum mismatch, the file is corrupted", ErrUnexpectedEndOfFile)
+			case errors.Is(err, io.ErrUnexpectedEOF) && !errors.As(err, &syntaxErr):
+				return fail("file is truncated", ErrUnexpectedEndOfFile)
+			case errors.As(err, &syntaxErr):
+				if strings.Contains(syntaxErr.Msg, "unexpected EOF") {
+					return fail("unexpected end of file: "+syntaxErr.Msg, ErrUnexpectedEndOfFile)
+				}
+				return fail(syntaxErr.Msg, ErrXMLParse)
+			default:
+				return fail(err.Error(), ErrInputOutputFailure)
+			}
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				if seenRoot {
+					return fail("more than one root element", ErrXMLParse)
+				}
+				if t.Name.Local != root {
+					return fail(fmt.Sprintf("root element is <%s>, expected <%s>", t.Name.Local, root), ErrXMLConsistency)
+				}
+				seenRoot = true
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+
+	if !seenRoot {
+		return fail("no <"+root+"> root element", ErrUnexpectedEndOfFile)
+	}
+	return nil
+}
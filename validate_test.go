@@ -0,0 +1,109 @@
+package wurfl_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	wurfl "github.com/WURFL/golang-wurfl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	return path
+}
+
+func zipBytes(t *testing.T, name string, data []byte) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	// stored, so that the test can corrupt the data without breaking the deflate stream
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestValidatePatchFile(t *testing.T) {
+	assert.NoError(t, wurfl.ValidatePatchFile(writeTestFile(t, "patch.xml", []byte(testPatch))))
+
+	zipped := zipBytes(t, "patch.xml", []byte(testPatch))
+	assert.NoError(t, wurfl.ValidatePatchFile(writeTestFile(t, "patch.zip", zipped)))
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write([]byte(testPatch))
+	gw.Close()
+	assert.NoError(t, wurfl.ValidatePatchFile(writeTestFile(t, "patch.xml.gz", gz.Bytes())))
+
+	// a patch is not a data file
+	err := wurfl.ValidateDataFile(writeTestFile(t, "patch.xml", []byte(testPatch)))
+	assert.ErrorIs(t, err, wurfl.ErrXMLConsistency)
+}
+
+func TestValidatePatchFile_Errors(t *testing.T) {
+	var dfErr *wurfl.DataFileError
+
+	malformed := "<wurfl_patch>\n  <devices>\n    <device id=\"x\">\n  </devices>\n</wurfl_patch>\n"
+	err := wurfl.ValidatePatchFile(writeTestFile(t, "malformed.xml", []byte(malformed)))
+	assert.ErrorIs(t, err, wurfl.ErrXMLParse)
+	require.True(t, errors.As(err, &dfErr))
+	assert.Equal(t, 4, dfErr.Line)
+	assert.Positive(t, dfErr.Column)
+
+	truncated := testPatch[:len(testPatch)/2]
+	err = wurfl.ValidatePatchFile(writeTestFile(t, "truncated.xml", []byte(truncated)))
+	assert.ErrorIs(t, err, wurfl.ErrUnexpectedEndOfFile)
+
+	zipped := zipBytes(t, "patch.xml", []byte(testPatch))
+	err = wurfl.ValidatePatchFile(writeTestFile(t, "truncated.zip", zipped[:len(zipped)/2]))
+	assert.ErrorIs(t, err, wurfl.ErrUnexpectedEndOfFile)
+
+	corrupted := bytes.Replace(zipped, []byte("GolangWurflTestAgent"), []byte("GolangWurflTestAgenT"), 1)
+	err = wurfl.ValidatePatchFile(writeTestFile(t, "corrupted.zip", corrupted))
+	assert.ErrorIs(t, err, wurfl.ErrUnexpectedEndOfFile)
+	require.True(t, errors.As(err, &dfErr))
+	assert.Equal(t, "patch.xml", dfErr.Entry)
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write([]byte(testPatch))
+	gw.Close()
+	err = wurfl.ValidatePatchFile(writeTestFile(t, "truncated.xml.gz", gz.Bytes()[:gz.Len()-6]))
+	assert.ErrorIs(t, err, wurfl.ErrUnexpectedEndOfFile)
+
+	err = wurfl.ValidatePatchFile(writeTestFile(t, "empty.xml", nil))
+	assert.ErrorIs(t, err, wurfl.ErrUnexpectedEndOfFile)
+
+	err = wurfl.ValidatePatchFile(writeTestFile(t, "data.bin", []byte("not a wurfl file")))
+	assert.ErrorIs(t, err, wurfl.ErrUpdaterWrongDataFormat)
+
+	err = wurfl.ValidatePatchFile("/nodir/patch.xml")
+	assert.ErrorIs(t, err, wurfl.ErrFileNotFound)
+}
+
+func TestValidateDataFile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("streams the whole WURFL data file")
+	}
+	assert.NoError(t, wurfl.ValidateDataFile(fixtureWurflZip()))
+}
+
+func TestCreateWithOptions_DataFileValidation(t *testing.T) {
+	patch := writeTestFile(t, "patch.xml", []byte("<wurfl_patch>\n<devices>\n</device>\n</wurfl_patch>\n"))
+
+	_, err := wurfl.CreateWithOptions(fixtureWurflZip(), wurfl.WithPatches(patch), wurfl.WithDataFileValidation())
+	assert.ErrorIs(t, err, wurfl.ErrXMLParse)
+	var dfErr *wurfl.DataFileError
+	require.True(t, errors.As(err, &dfErr))
+	assert.Equal(t, patch, dfErr.Path)
+	assert.Equal(t, 3, dfErr.Line)
+}
@@ -0,0 +1,200 @@
+package wurfl
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidVersion is returned when a libwurfl version string cannot be parsed
+var ErrInvalidVersion = errors.New("invalid libwurfl version")
+
+// ErrUnsupportedLibwurfl is returned by the options and methods that need a newer
+// libwurfl than the linked one. The actual error is an *UnsupportedLibwurflError.
+var ErrUnsupportedLibwurfl = errors.New("feature not supported by the linked libwurfl")
+
+// VersionNumber is a parsed libwurfl version, ie: 1.13.4.0
+type VersionNumber [4]int
+
+// ParseVersion parses a dotted version of 1 to 4 numeric parts; missing parts are 0,
+// so "1.13" equals "1.13.0.0".
+func ParseVersion(v string) (VersionNumber, error) {
+	var n VersionNumber
+	parts := strings.Split(strings.TrimSpace(v), ".")
+	if len(parts) > len(n) {
+		return n, fmt.Errorf("%w: %q", ErrInvalidVersion, v)
+	}
+	for i, p := range parts {
+		num, err := strconv.Atoi(p)
+		if err != nil || num < 0 {
+			return VersionNumber{}, fmt.Errorf("%w: %q", ErrInvalidVersion, v)
+		}
+		n[i] = num
+	}
+	return n, nil
+}
+
+// Compare returns -1, 0 or 1 if v is lower, equal or greater than other
+func (v VersionNumber) Compare(other VersionNumber) int {
+	for i := range v {
+		if v[i] < other[i] {
+			return -1
+		}
+		if v[i] > other[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// String returns the version in its dotted form
+func (v VersionNumber) String() string {
+	return fmt.Sprintf("%d.%d.%d.%d", v[0], v[1], v[2], v[3])
+}
+
+// CompareVersionStrings compares two dotted versions, returning -1, 0 or 1 if v1 is
+// lower, equal or greater than v2, or an error wrapping ErrInvalidVersion if one of
+// them cannot be parsed.
+func CompareVersionStrings(v1, v2 string) (int, error) {
+	n1, err := ParseVersion(v1)
+	if err != nil {
+		return 0, err
+	}
+	n2, err := ParseVersion(v2)
+	if err != nil {
+		return 0, err
+	}
+	return n1.Compare(n2), nil
+}
+
+// Feature is a functionality of this package that depends on the linked libwurfl version
+type Feature string
+
+// Features depending on the libwurfl version
+const (
+	// FeatureCapabilityFallbackCache is the WurflAttrCapabilityFallbackCache attribute
+	FeatureCapabilityFallbackCache Feature = "capability_fallback_cache"
+	// FeatureUpdaterUserAgent is the custom updater user agent (SetUpdaterUserAgent, GetUpdaterUserAgent)
+	FeatureUpdaterUserAgent Feature = "updater_user_agent"
+	// FeatureErrorMapping is the mapping of libwurfl error codes to the Err* sentinels of err.go.
+	// With an older libwurfl the error codes may not match the wurfl.h the package was built
+	// with: errors keep the libwurfl message but wrap no sentinel.
+	FeatureErrorMapping Feature = "error_mapping"
+)
+
+// featureMinVersion holds the first libwurfl version supporting each Feature
+var featureMinVersion = map[Feature]VersionNumber{
+	FeatureCapabilityFallbackCache: {1, 12, 9, 3},
+	FeatureUpdaterUserAgent:        {1, 13, 0, 0},
+	FeatureErrorMapping:            {1, 13, 4, 0},
+}
+
+// MinVersion returns the first libwurfl version supporting f
+func (f Feature) MinVersion() (VersionNumber, bool) {
+	v, ok := featureMinVersion[f]
+	return v, ok
+}
+
+// UnsupportedLibwurflError reports a Feature that needs a newer libwurfl than the linked one
+type UnsupportedLibwurflError struct {
+	Feature  Feature
+	Required VersionNumber
+	Actual   string
+}
+
+// Error returns the feature with the required and the actual libwurfl versions.
+func (e *UnsupportedLibwurflError) Error() string {
+	return fmt.Sprintf("wurfl: %s needs libwurfl %s or above, linked version is %s", e.Feature, e.Required, e.Actual)
+}
+
+// Unwrap returns ErrUnsupportedLibwurfl, allowing errors.Is to work.
+func (e *UnsupportedLibwurflError) Unwrap() error {
+	return ErrUnsupportedLibwurfl
+}
+
+// FeatureReport lists the features supported by a libwurfl version
+type FeatureReport struct {
+	APIVersion string
+	Version    VersionNumber
+	Supported  map[Feature]bool
+}
+
+// Has reports whether f is supported
+func (r *FeatureReport) Has(f Feature) bool {
+	return r.Supported[f]
+}
+
+// Require returns an *UnsupportedLibwurflError if f is not supported
+func (r *FeatureReport) Require(f Feature) error {
+	if r.Supported[f] {
+		return nil
+	}
+	min, _ := f.MinVersion()
+	return &UnsupportedLibwurflError{Feature: f, Required: min, Actual: r.APIVersion}
+}
+
+// Missing returns the unsupported features, sorted by name
+func (r *FeatureReport) Missing() []Feature {
+	var missing []Feature
+	for f, ok := range r.Supported {
+		if !ok {
+			missing = append(missing, f)
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool { return missing[i] < missing[j] })
+	return missing
+}
+
+// FeaturesOf returns the features supported by the given libwurfl version
+func FeaturesOf(apiVersion string) (*FeatureReport, error) {
+	v, err := ParseVersion(apiVersion)
+	if err != nil {
+		return nil, err
+	}
+	r := &FeatureReport{APIVersion: apiVersion, Version: v, Supported: make(map[Feature]bool, len(featureMinVersion))}
+	for f, min := range featureMinVersion {
+		r.Supported[f] = v.Compare(min) >= 0
+	}
+	return r, nil
+}
+
+var (
+	featuresOnce   sync.Once
+	linkedFeatures *FeatureReport
+	linkedErr      error
+)
+
+// linked returns the features of the linked libwurfl, computed once
+func linked() (*FeatureReport, error) {
+	featuresOnce.Do(func() {
+		linkedFeatures, linkedErr = FeaturesOf(APIVersion())
+	})
+	return linkedFeatures, linkedErr
+}
+
+// Features returns the features supported by the linked libwurfl, as reported by APIVersion()
+func Features() (*FeatureReport, error) {
+	lr, err := linked()
+	if err != nil {
+		return nil, err
+	}
+	// callers get their own copy of the report
+	r := *lr
+	r.Supported = make(map[Feature]bool, len(lr.Supported))
+	for f, ok := range lr.Supported {
+		r.Supported[f] = ok
+	}
+	return &r, nil
+}
+
+// requireFeature returns an error if the linked libwurfl does not support f
+func requireFeature(f Feature) error {
+	r, err := linked()
+	if err != nil {
+		return err
+	}
+	return r.Require(f)
+}
@@ -0,0 +1,102 @@
+package wurfl_test
+
+import (
+	"errors"
+	"testing"
+
+	wurfl "github.com/WURFL/golang-wurfl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVersion(t *testing.T) {
+	v, err := wurfl.ParseVersion("1.13.4.0")
+	require.NoError(t, err)
+	assert.Equal(t, wurfl.VersionNumber{1, 13, 4, 0}, v)
+	assert.Equal(t, "1.13.4.0", v.String())
+
+	v, err = wurfl.ParseVersion("1.13")
+	require.NoError(t, err)
+	assert.Equal(t, wurfl.VersionNumber{1, 13, 0, 0}, v)
+
+	for _, bad := range []string{"", "1..2", "1.2.3.4.5", "1.x.3.4", "1.-2", "v1.13"} {
+		_, err := wurfl.ParseVersion(bad)
+		assert.ErrorIs(t, err, wurfl.ErrInvalidVersion, bad)
+	}
+}
+
+func TestCompareVersionStrings(t *testing.T) {
+	result, err := wurfl.CompareVersionStrings("1.13", "1.13.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result)
+
+	result, err = wurfl.CompareVersionStrings("1.12.9.3", "1.13")
+	assert.NoError(t, err)
+	assert.Equal(t, -1, result)
+
+	_, err = wurfl.CompareVersionStrings("1.13.0.0", "1.13.a")
+	assert.ErrorIs(t, err, wurfl.ErrInvalidVersion)
+
+	// the deprecated CompareVersions must not panic on short or malformed versions
+	assert.Equal(t, 0, wurfl.CompareVersions("1.13", "1.13.0.0"))
+	assert.Equal(t, 1, wurfl.CompareVersions("2", "1.13.0.0"))
+	assert.Equal(t, 0, wurfl.CompareVersions("", "1.13.0.0"))
+}
+
+func TestFeaturesOf(t *testing.T) {
+	r, err := wurfl.FeaturesOf("1.12.9.3")
+	require.NoError(t, err)
+	assert.True(t, r.Has(wurfl.FeatureCapabilityFallbackCache))
+	assert.False(t, r.Has(wurfl.FeatureUpdaterUserAgent))
+	assert.Equal(t, []wurfl.Feature{wurfl.FeatureErrorMapping, wurfl.FeatureUpdaterUserAgent}, r.Missing())
+
+	err = r.Require(wurfl.FeatureUpdaterUserAgent)
+	assert.ErrorIs(t, err, wurfl.ErrUnsupportedLibwurfl)
+	var unsupported *wurfl.UnsupportedLibwurflError
+	require.True(t, errors.As(err, &unsupported))
+	assert.Equal(t, wurfl.VersionNumber{1, 13, 0, 0}, unsupported.Required)
+	assert.Equal(t, "1.12.9.3", unsupported.Actual)
+
+	r, err = wurfl.FeaturesOf("1.13.4.0")
+	require.NoError(t, err)
+	assert.Empty(t, r.Missing())
+
+	_, err = wurfl.FeaturesOf("unknown")
+	assert.ErrorIs(t, err, wurfl.ErrInvalidVersion)
+}
+
+func TestFeatures(t *testing.T) {
+	r, err := wurfl.Features()
+	require.NoError(t, err)
+	assert.Equal(t, wurfl.APIVersion(), r.APIVersion)
+
+	// the report is a copy
+	r.Supported[wurfl.FeatureUpdaterUserAgent] = !r.Supported[wurfl.FeatureUpdaterUserAgent]
+	r2, err := wurfl.Features()
+	require.NoError(t, err)
+	assert.NotEqual(t, r.Supported[wurfl.FeatureUpdaterUserAgent], r2.Supported[wurfl.FeatureUpdaterUserAgent])
+}
+
+func TestWithAttr_UnsupportedLibwurfl(t *testing.T) {
+	r, err := wurfl.Features()
+	require.NoError(t, err)
+	if r.Has(wurfl.FeatureCapabilityFallbackCache) {
+		t.Skip("linked libwurfl supports the capability fallback cache")
+	}
+
+	_, err = wurfl.CreateWithOptions(fixtureWurflZip(),
+		wurfl.WithAttr(wurfl.WurflAttrCapabilityFallbackCache, wurfl.WurflAttrCapabilityFallbackCacheLimited))
+	assert.ErrorIs(t, err, wurfl.ErrUnsupportedLibwurfl)
+}
+
+func TestWurfl_GetUpdaterUserAgent_UnsupportedLibwurfl(t *testing.T) {
+	r, err := wurfl.Features()
+	require.NoError(t, err)
+	if r.Has(wurfl.FeatureUpdaterUserAgent) {
+		t.Skip("linked libwurfl supports the updater user agent")
+	}
+
+	wengine := fixtureCreateEngine(t)
+	defer wengine.Destroy()
+	assert.Empty(t, wengine.GetUpdaterUserAgent())
+}
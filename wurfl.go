@@ -16,11 +16,14 @@ package wurfl
 import "C"
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
-	"strconv"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"unsafe"
 )
 
@@ -134,29 +137,66 @@ const (
 // Why a trie: LookupWithImportantHeaderMap receives a map[string]string where keys are
 // header names in arbitrary case (e.g. "User-Agent", "user-agent", "USER-AGENT").
 // We need to match them against WURFL's known important header names case-insensitively.
-// The trie avoids this by folding case inline during traversal using a bit trick,
+// The trie avoids this by folding case inline during traversal with a lookup table,
 // achieving O(len(key)) lookup with zero allocations.
 //
-// How case folding works: each byte is OR-ed with 0x20 (bit 5), which converts ASCII
-// uppercase letters to lowercase (e.g. 'A' 0x41 -> 'a' 0x61) without affecting lowercase
-// letters, digits, or hyphens — the only characters that appear in HTTP header names.
-// Both set() and get() apply the same folding, so "Sec-CH-UA" and "sec-ch-ua" follow
-// the same path in the trie.
+// How case folding works: a header name is an RFC 9110 token, made of letters, digits and
+// the 15 characters "!#$%&'*+-.^_`|~". tokenIndex maps each of these bytes to its own child
+// slot, the upper and lower case of a letter sharing the same one. Every other byte (controls,
+// separators, spaces, non-ASCII) maps to noToken: such a name is not a valid header name and
+// get() reports it as not found, whatever the key, so client supplied names cannot crash it.
 //
 // The stored value at each terminal node is a *C.char pointer to a C string allocated once
 // at engine initialization. This means get() returns a ready-to-use C string directly,
-// avoiding a C.CString() call (and its cgo malloc overhead) on every lookup.
+// avoiding a cString() call (and its cgo malloc overhead) on every lookup.
 type headerTrie struct {
-	children [128]*headerTrie
+	children [tokenChars]*headerTrie
 	value    *C.char // non-nil at terminal nodes, points to pre-allocated C string
 }
 
+// tokenSpecials are the RFC 9110 tchar that are neither letters nor digits
+const tokenSpecials = "!#$%&'*+-.^_`|~"
+
+// tokenChars is the number of distinct case-folded token characters
+const tokenChars = len(tokenSpecials) + 10 + 26
+
+// noToken is the tokenIndex of the bytes that cannot appear in a header name
+const noToken = 0xFF
+
+// tokenIndex maps every byte to its child slot in headerTrie, or noToken
+var tokenIndex = func() (index [256]uint8) {
+	for i := range index {
+		index[i] = noToken
+	}
+	n := uint8(0)
+	for i := 0; i < len(tokenSpecials); i++ {
+		index[tokenSpecials[i]] = n
+		n++
+	}
+	for c := '0'; c <= '9'; c++ {
+		index[c] = n
+		n++
+	}
+	for c := 'a'; c <= 'z'; c++ {
+		index[c] = n
+		index[c-'a'+'A'] = n
+		n++
+	}
+	return index
+}()
+
 // set inserts a header name into the trie, associating it with a pre-allocated C string.
-// Called once per important header at engine initialization.
+// Called once per important header at engine initialization. A name that is not a valid
+// token cannot be matched and is not inserted.
 func (t *headerTrie) set(key string, val *C.char) {
+	for i := 0; i < len(key); i++ {
+		if tokenIndex[key[i]] == noToken {
+			return
+		}
+	}
 	node := t
 	for i := 0; i < len(key); i++ {
-		c := key[i] | 0x20 // fold to lowercase: safe for [A-Za-z0-9\-]
+		c := tokenIndex[key[i]]
 		if node.children[c] == nil {
 			node.children[c] = &headerTrie{}
 		}
@@ -166,11 +206,15 @@ func (t *headerTrie) set(key string, val *C.char) {
 }
 
 // get performs a case-insensitive lookup and returns the pre-allocated *C.char for the
-// header name, or (nil, false) if the key is not a known important header.
+// header name, or (nil, false) if the key is not a known important header. It accepts
+// any string, invalid header names are just not found.
 func (t *headerTrie) get(key string) (*C.char, bool) {
 	node := t
 	for i := 0; i < len(key); i++ {
-		c := key[i] | 0x20 // same case folding as set()
+		c := tokenIndex[key[i]]
+		if c == noToken {
+			return nil, false
+		}
 		node = node.children[c]
 		if node == nil {
 			return nil, false
@@ -180,12 +224,32 @@ func (t *headerTrie) get(key string) (*C.char, bool) {
 }
 
 // Wurfl represents internal wurfl infuze handle
+//
+// A Wurfl is safe for concurrent use: lookups run in parallel with each other and with
+// SetAttr, Reload, the updater and Destroy. Every lookup uses the engine (data, important
+// headers, capability names) current when it starts; Reload builds a new engine and swaps
+// it in, leaving the running lookups on the previous one. SetAttr and SetAttrs change the
+// attributes of the current engine in place instead: a lookup running meanwhile may see
+// the old or the new value, and the important headers of the old or of the new one. A
+// Device is safe for concurrent reads, but must not be used after, or concurrently with,
+// its own Destroy.
+//
+// The exported fields are set by Create and cleared by Destroy only: they describe the
+// engine loaded by Create, not the changes made by Reload and SetAttr.
 type Wurfl struct {
-	Wurfl                       C.wurfl_handle
-	ImportantHeaderNames        []string
-	importantHeaderCStringNames []*C.char
-	importantHeaderTrie         headerTrie
-	capsCStringcache            map[string]*C.char
+	// Deprecated: Wurfl is the raw libwurfl handle of the engine loaded by Create, not safe
+	// for concurrent use. It is left unchanged by Reload, which frees that engine.
+	Wurfl C.wurfl_handle
+	// Deprecated: ImportantHeaderNames are the important headers of the engine loaded by
+	// Create, not updated by Reload and SetAttr. Use GetImportantHeaderNames instead.
+	ImportantHeaderNames []string
+
+	engine  atomic.Pointer[engine] // current engine, see engine.go
+	mu      sync.Mutex             // serializes Reload, Destroy and the setters below
+	opts    *options               // effective settings, re-applied by Reload
+	retired []*engine              // engines replaced by Reload, still used by some Device
+	alloc   *allocation            // creation stack, see leak.go
+	limiter *limiter               // nil unless the lookups are limited, see limiter.go
 }
 
 // Device represent internal matched device handle
@@ -193,6 +257,10 @@ type Device struct {
 	Device           C.wurfl_device_handle
 	Wurfl            C.wurfl_handle
 	capsCStringcache map[string]*C.char
+	engine           *engine                  // keeps the engine the device comes from alive
+	usage            *CapabilityUsageRecorder // nil unless recording
+	alloc            *allocation              // creation stack, see leak.go
+	fallback         bool                     // see IsFallback
 }
 
 // WurflHandler defines API methods for the Wurfl Infuze handle
@@ -268,10 +336,10 @@ func APIVersion() string {
 // Download downloads the WURFL data file from the specified URL and saves it to the specified folder.
 // If the download is successful, it returns nil. Otherwise, it returns an error.
 func Download(url string, folder string) error {
-	cURL := C.CString(url)
-	cFolder := C.CString(folder)
-	defer C.free(unsafe.Pointer(cURL))
-	defer C.free(unsafe.Pointer(cFolder))
+	cURL := cString(url)
+	cFolder := cString(folder)
+	defer cFree(cURL)
+	defer cFree(cFolder)
 	cerr := C.wurfl_download(cURL, cFolder)
 	if cerr != C.WURFL_OK {
 		errMsg := C.GoString(C.wurfl_get_error_string(cerr))
@@ -283,292 +351,350 @@ func Download(url string, folder string) error {
 // Create the wurfl engine. Parameters :
 // Wurflxml : path to the wurfl.xml/zip file
 // Patches : slice of paths of patches files to load
-// CapFilter : list of capabilities used; allow to init engine without loading all 500+ caps
+// CapFilter : list of capabilities used; allow to init engine without loading all 500+ caps.
 //
 //	Note : Capability filtering is discouraged and will be deprecated in future versions
 //
 // DEPRECATED: EngineTarget : As of 1.9.5.0 has no effect anymore
 // CacheProvider : WurflCacheProviderLru
 // CacheExtraConfig : size of single lru cache in the form "100000"
+//
+// Create is a wrapper around CreateWithOptions, which should be preferred in new code.
 func Create(Wurflxml string, Patches []string, CapFilter []string, EngineTarget int, CacheProvider int, CacheExtraConfig string) (*Wurfl, error) {
-	w := &Wurfl{}
-
-	w.Wurfl = C.wurfl_create()
+	return CreateWithOptions(Wurflxml,
+		WithPatches(Patches...),
+		WithCapabilityFilter(CapFilter...),
+		withCacheConfig(CacheProvider, CacheExtraConfig))
+}
 
-	if w.Wurfl == nil {
-		// error in create : no way to get the error as the is no engine instance yet
-		// in libwurfl. We can only return a generic memory allocation error
-		return nil, cErrorToGoError(C.WURFL_ERROR_UNABLE_TO_ALLOCATE_MEMORY)
+// create performs the actual engine creation, applying o around wurfl_load
+func create(Wurflxml string, o *options) (*Wurfl, error) {
+	e, err := newEngine(Wurflxml, o)
+	if err != nil {
+		return nil, err
 	}
 
-	// setting cache if specified
-	if CacheProvider != WurflCacheProviderDefault {
-		ccacheec := C.CString(CacheExtraConfig)
+	// the staging directory now belongs to the engine
+	o.stagingDir = ""
 
-		cp := C.wurfl_cache_provider(CacheProvider)
-		C.wurfl_set_cache_provider(w.Wurfl, cp, ccacheec)
-		C.free(unsafe.Pointer(ccacheec))
+	w := &Wurfl{
+		Wurfl:                e.handle,
+		ImportantHeaderNames: e.headers.Load().Names(),
+		opts:                 o,
+		limiter:              newLimiter(o.limit),
 	}
+	w.install(e)
+	w.track(o.leaks)
 
-	// setting wurfl.xml
-	wxml := C.CString(Wurflxml)
-	defer C.free(unsafe.Pointer(wxml))
-	if ret := C.wurfl_set_root(w.Wurfl, wxml); ret != C.WURFL_OK {
-		w.Destroy()
-		return nil, cErrorToGoError(ret)
-	}
-
-	// setting patches
-	for i := 0; i < len(Patches); i++ {
-		cpatch := C.CString(Patches[i])
-		if ret := C.wurfl_add_patch(w.Wurfl, cpatch); ret != C.WURFL_OK {
-			C.free(unsafe.Pointer(cpatch))
+	if o.updaterStart {
+		if err := e.updaterStart(); err != nil {
 			w.Destroy()
-			return nil, cErrorToGoError(ret)
+			return nil, &OptionError{Option: "WithUpdaterStart", Err: err}
 		}
-		C.free(unsafe.Pointer(cpatch))
 	}
 
-	// filter capabilities in engine
-	for i := 0; i < len(CapFilter); i++ {
-		ccap := C.CString(CapFilter[i])
-		if ret := C.wurfl_add_requested_capability(w.Wurfl, ccap); ret != C.WURFL_OK {
-			C.free(unsafe.Pointer(ccap))
-			w.Destroy()
-			return nil, cErrorToGoError(ret)
+	return w, nil
+}
+
+// install makes e the current engine
+func (w *Wurfl) install(e *engine) {
+	w.engine.Store(e)
+}
+
+// acquire returns the current engine with a reference taken on it, or nil if the
+// Wurfl has been destroyed. The caller must release it.
+func (w *Wurfl) acquire() *engine {
+	for {
+		e := w.engine.Load()
+		if e == nil {
+			return nil
 		}
-		C.free(unsafe.Pointer(ccap))
+		if e.tryAcquire() {
+			return e
+		}
+		// e has just been retired by a concurrent Reload, load the new one
 	}
+}
 
-	// loading engine
-	if C.wurfl_load(w.Wurfl) != C.WURFL_OK {
-		// we prefer wurfl handle based error message as it is richer than the standard one
-		err := checkHandleError(w.Wurfl)
-		w.Destroy()
-		return nil, err
+// Reload loads a new WURFL data file and set of patches into the engine, keeping the
+// cache, capability filter, attributes, log and updater settings currently in use.
+// Lookups can continue on other goroutines while the new data is loaded: once it is
+// ready, the engine and all Go-side caches (important headers, capability names)
+// are switched atomically. Devices obtained before the switch stay valid until their
+// Destroy. If the new data fails to load, an error is returned and the old data stays active.
+func (w *Wurfl) Reload(path string, patches []string) error {
+	if path == "" {
+		return cErrorToGoError(C.WURFL_ERROR_ROOT_NOT_SET)
 	}
 
-	// prepare important headers slice
-	ihe := C.wurfl_get_important_header_enumerator(w.Wurfl)
-	if ihe == nil { // Check if enumerator creation failed
-		err := checkHandleError(w.Wurfl)
-		w.Destroy()
-		return nil, err
-	}
-	defer C.wurfl_important_header_enumerator_destroy(ihe)
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-	for i := 0; C.wurfl_important_header_enumerator_is_valid(ihe) != 0; i++ {
-		// get the header name
-		headerName := C.wurfl_important_header_enumerator_get_value(ihe)
-		// convert header name to go string
-		gheaderName := C.GoString(headerName)
-		// create a C string copy from the go string
-		cheaderName := C.CString(gheaderName) // This CString needs to be managed (freed in Destroy)
-		// append to slice
-		w.ImportantHeaderNames = append(w.ImportantHeaderNames, gheaderName)
-		w.importantHeaderCStringNames = append(w.importantHeaderCStringNames, cheaderName)
-		// advance
-		C.wurfl_important_header_enumerator_move_next(ihe)
+	old := w.engine.Load()
+	if old == nil {
+		return ErrEngineClosed
 	}
 
-	// build a trie-based cache for important header names, for case-insensitive lookup without allocation
-	w.importantHeaderTrie = headerTrie{}
-	for i, name := range w.ImportantHeaderNames {
-		w.importantHeaderTrie.set(name, w.importantHeaderCStringNames[i])
+	o := w.opts.clone()
+	o.patches = nil
+	for _, p := range patches {
+		o.patches = append(o.patches, patchSource{path: p})
 	}
 
-	// initialize caps/vcaps CString cache for faster calls to libwurfl
-
-	caps := w.GetAllCaps()
-	vcaps := w.GetAllCaps()
-
-	w.capsCStringcache = make(map[string]*C.char, len(caps)+len(vcaps))
-
-	for c := range caps {
-		w.capsCStringcache[caps[c]] = C.CString(caps[c])
+	e, err := newEngine(path, o)
+	if err != nil {
+		return err
 	}
 
-	for v := range vcaps {
-		w.capsCStringcache[vcaps[v]] = C.CString(vcaps[v])
+	// only one updater must run: stop the old one before starting the new one
+	if o.updaterStart {
+		if err := old.updaterStop(); err != nil {
+			e.free()
+			return err
+		}
+		if err := e.updaterStart(); err != nil {
+			e.free()
+			// restart the old updater, the old data stays active
+			_ = old.updaterStart()
+			return err
+		}
 	}
 
-	return w, nil
+	w.opts = o
+	w.install(e)
+	w.retire(old)
+	return nil
+}
+
+// retire drops the Wurfl reference on a replaced engine, which is freed once
+// the last in-flight call or Device using it is done
+func (w *Wurfl) retire(old *engine) {
+	// forget engines already freed
+	retired := w.retired[:0]
+	for _, r := range w.retired {
+		if r.refs.Load() > 0 {
+			retired = append(retired, r)
+		}
+	}
+	w.retired = append(retired, old)
+	old.unref()
 }
 
 // Destroy the wurfl engine
+// Calls in progress on other goroutines are waited for; after Destroy every Wurfl and
+// Device method returns ErrEngineClosed, or a zero value when it has no error result.
+// Destroying the Devices obtained before remains safe. See Shutdown for a graceful stop.
 func (w *Wurfl) Destroy() {
-	if w.Wurfl != nil {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-		// deallocate important headers C strings
-		for _, importantHeaderName := range w.importantHeaderCStringNames {
-			if importantHeaderName != nil {
-				C.free(unsafe.Pointer(importantHeaderName))
-			}
-		}
+	if e := w.engine.Swap(nil); e != nil {
+		e.close()
+		w.Wurfl = nil
+		w.untrack()
+	}
 
-		// now free the caps/vcaps CStrings cache
+	// engines replaced by Reload and still used by some Device are freed too
+	for _, r := range w.retired {
+		r.close()
+	}
+	w.retired = nil
+}
 
-		for _, v := range w.capsCStringcache {
-			if v != nil {
-				C.free(unsafe.Pointer(v))
-			}
-		}
-		w.capsCStringcache = nil // Clear the map
-		C.wurfl_destroy(w.Wurfl)
-		w.Wurfl = nil
+// drain stops the updater and drops the Wurfl own reference on its engine, without
+// forcing it out like Destroy does: the engine is freed when the last Device obtained
+// from it is destroyed.
+func (w *Wurfl) drain() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	e := w.engine.Swap(nil)
+	if e == nil {
+		return
 	}
+	if w.opts.updaterStart {
+		_ = e.updaterStop()
+	}
+	w.Wurfl = nil
+	w.untrack()
+	e.unref()
 }
 
 // SetAttr : set engine attributes
+// attr and value are validated first (see Attr.Validate), unknown ones return ErrInvalidParameter.
+// The attribute is changed in place on the current engine, see the Wurfl comment.
 func (w *Wurfl) SetAttr(attr int, value int) error {
-	cattr := C.wurfl_attr(attr)
-	cvalue := C.int(value)
-	if C.wurfl_set_attr(w.Wurfl, cattr, cvalue) != C.WURFL_OK {
-		return checkHandleError(w.Wurfl)
-	}
-
-	// now reload all important header in wurfl struct since they are different
-	// if the attr is WurflAttrExtraHeadersExperimental
-	if attr == WurflAttrExtraHeadersExperimental {
-		ihe := C.wurfl_get_important_header_enumerator(w.Wurfl)
-
-		w.ImportantHeaderNames = nil
-		// deallocate important headers C strings
-		for _, importantHeaderName := range w.importantHeaderCStringNames {
-			C.free(unsafe.Pointer(importantHeaderName))
-		}
-		w.importantHeaderCStringNames = nil
-
-		for i := 0; C.wurfl_important_header_enumerator_is_valid(ihe) != 0; i++ {
-			// get the header name
-			headerName := C.wurfl_important_header_enumerator_get_value(ihe)
-			// convert header name to go string
-			gheaderName := C.GoString(headerName)
-			// create a C string copy from the go string
-			cheaderName := C.CString(gheaderName)
-			// append to slice
-			w.ImportantHeaderNames = append(w.ImportantHeaderNames, gheaderName)
-			w.importantHeaderCStringNames = append(w.importantHeaderCStringNames, cheaderName)
-			// advance
-			C.wurfl_important_header_enumerator_move_next(ihe)
-		}
-		C.wurfl_important_header_enumerator_destroy(ihe)
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-		// rebuild the trie-based cache for important header names
-		w.importantHeaderTrie = headerTrie{}
-		for i, name := range w.ImportantHeaderNames {
-			w.importantHeaderTrie.set(name, w.importantHeaderCStringNames[i])
-		}
+	e := w.acquire()
+	if e == nil {
+		return ErrEngineClosed
 	}
+	defer e.release()
 
+	if err := e.setAttr(attr, value); err != nil {
+		return err
+	}
+	w.opts.setAttr(attr, value)
 	return nil
 }
 
 // GetAttr : get engine attributes
 func (w *Wurfl) GetAttr(attr int) (int, error) {
-	cattr := C.wurfl_attr(attr)
-	var cvalue C.int
-	if C.wurfl_get_attr(w.Wurfl, cattr, &cvalue) != C.WURFL_OK {
-		return 0, checkHandleError(w.Wurfl)
+	e := w.acquire()
+	if e == nil {
+		return 0, ErrEngineClosed
 	}
-	return int(cvalue), nil
+	defer e.release()
+
+	return e.getAttr(attr)
 }
 
 // SetLogPath - set path of main libwurfl log file (updater has a separate log file)
 func (w *Wurfl) SetLogPath(LogFile string) error {
-	// wurfl_error wurfl_set_log_path(wurfl_handle hwurfl, const char *log_path);
-	clog := C.CString(LogFile)
-	ret := C.wurfl_set_log_path(w.Wurfl, clog)
-	C.free(unsafe.Pointer(clog))
-	if ret != C.WURFL_OK {
-		return cErrorToGoError(ret)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	e := w.acquire()
+	if e == nil {
+		return ErrEngineClosed
 	}
+	defer e.release()
+
+	if err := e.setLogPath(LogFile); err != nil {
+		return err
+	}
+	w.opts.logPath = LogFile
 	return nil
 }
 
 // SetUpdaterDataURL - set your scientiamobile WURFL Snapshot URL
 func (w *Wurfl) SetUpdaterDataURL(DataURL string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-	apiVersion := w.GetAPIVersion()
-	// we set useragent only if API version is >= 1.13.0.0 otherwise it will overwrite the libwurfl one
-	if CompareVersions(apiVersion, "1.13.0.0") >= 0 {
-		golangUA := "infuze_golang/" + Version
-		cgolangUA := C.CString(golangUA)
-		cret := C.wurfl_updater_set_useragent(w.Wurfl, cgolangUA)
-		C.free(unsafe.Pointer(cgolangUA))
-		if cret != C.WURFL_OK {
-			return cErrorToGoError(cret)
-		}
+	e := w.acquire()
+	if e == nil {
+		return ErrEngineClosed
 	}
+	defer e.release()
 
-	cdata := C.CString(DataURL)
-
-	ret := C.wurfl_updater_set_data_url(w.Wurfl, cdata)
-	C.free(unsafe.Pointer(cdata))
-
-	if ret != C.WURFL_OK {
-		return checkHandleError(w.Wurfl)
+	if err := e.setUpdaterDataURL(DataURL); err != nil {
+		return err
 	}
+	w.opts.updaterDataURL = DataURL
+	// the golang user agent has replaced any custom one
+	w.opts.updaterUserAgent = ""
 	return nil
 }
 
 // SetUpdaterUserAgent - set the UserAgent used in calling the WURFL Snapshot server
+// Returns an error wrapping ErrUnsupportedLibwurfl on libwurfl older than 1.13.0.0
 func (w *Wurfl) SetUpdaterUserAgent(userAgent string) error {
-	cdata := C.CString(userAgent)
-	ret := C.wurfl_updater_set_useragent(w.Wurfl, cdata)
-	C.free(unsafe.Pointer(cdata))
-	if ret != C.WURFL_OK {
-		return checkHandleError(w.Wurfl)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	e := w.acquire()
+	if e == nil {
+		return ErrEngineClosed
 	}
+	defer e.release()
+
+	if err := e.setUpdaterUserAgent(userAgent); err != nil {
+		return err
+	}
+	w.opts.updaterUserAgent = userAgent
 	return nil
 }
 
-// GetUpdaterUserAgent - gets the UserAgent used in calling the WURFL Snapshot server
+// GetUpdaterUserAgent - gets the UserAgent used in calling the WURFL Snapshot server.
+// It returns an empty string if the linked libwurfl does not support FeatureUpdaterUserAgent.
 func (w *Wurfl) GetUpdaterUserAgent() string {
-	ua := C.wurfl_updater_get_useragent(w.Wurfl)
+	if requireFeature(FeatureUpdaterUserAgent) != nil {
+		return ""
+	}
+
+	e := w.acquire()
+	if e == nil {
+		return ""
+	}
+	defer e.release()
+
+	ua := C.wurfl_updater_get_useragent(e.handle)
 	uaValue := C.GoString(ua)
 	return uaValue
 }
 
 // SetUpdaterDataFrequency - set frequency of update checks
 func (w *Wurfl) SetUpdaterDataFrequency(Frequency int) error {
-	//     LIBWURFLAPI wurfl_error wurfl_updater_set_data_frequency(wurfl_handle hwurfl, wurfl_updater_frequency freq);
-	cfreq := C.wurfl_updater_frequency(Frequency)
-	if C.wurfl_updater_set_data_frequency(w.Wurfl, cfreq) != C.WURFL_OK {
-		return checkHandleError(w.Wurfl)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	e := w.acquire()
+	if e == nil {
+		return ErrEngineClosed
 	}
+	defer e.release()
+
+	if err := e.setUpdaterDataFrequency(Frequency); err != nil {
+		return err
+	}
+	w.opts.updaterFrequency = Frequency
+	w.opts.updaterFrequencySet = true
 	return nil
 }
 
 // SetUpdaterDataURLTimeout - set connection and data transfer timeouts (in millisecs) for updater
 // http call. 0 for no timeout, -1 for defaults
 func (w *Wurfl) SetUpdaterDataURLTimeout(ConnectionTimeout int, DataTransferTimeout int) error {
-	// wurfl_error wurfl_updater_set_data_url_timeouts(wurfl_handle hwurfl, int connection_timeout, int data_transfer_timeout);
-	cConn := C.int(ConnectionTimeout)
-	cData := C.int(DataTransferTimeout)
-	if C.wurfl_updater_set_data_url_timeouts(w.Wurfl, cConn, cData) != C.WURFL_OK {
-		return checkHandleError(w.Wurfl)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	e := w.acquire()
+	if e == nil {
+		return ErrEngineClosed
+	}
+	defer e.release()
+
+	if err := e.setUpdaterDataURLTimeout(ConnectionTimeout, DataTransferTimeout); err != nil {
+		return err
 	}
+	w.opts.updaterConnTimeout = ConnectionTimeout
+	w.opts.updaterTransferTimeout = DataTransferTimeout
+	w.opts.updaterTimeoutsSet = true
 	return nil
 }
 
 // SetUpdaterLogPath - set path of updater log file
 func (w *Wurfl) SetUpdaterLogPath(LogFile string) error {
-	//     LIBWURFLAPI wurfl_error wurfl_updater_set_log_path(wurfl_handle hwurfl, const char* log_path);
-	clog := C.CString(LogFile)
-	ret := C.wurfl_updater_set_log_path(w.Wurfl, clog)
-	C.free(unsafe.Pointer(clog))
-	if ret != C.WURFL_OK {
-		return checkHandleError(w.Wurfl)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	e := w.acquire()
+	if e == nil {
+		return ErrEngineClosed
+	}
+	defer e.release()
+
+	if err := e.setUpdaterLogPath(LogFile); err != nil {
+		return err
 	}
+	w.opts.updaterLogPath = LogFile
 	return nil
 }
 
 // UpdaterRunonce - Update the wurfl if needed and terminate
 func (w *Wurfl) UpdaterRunonce() error {
+	e := w.acquire()
+	if e == nil {
+		return ErrEngineClosed
+	}
+	defer e.release()
+
 	//     LIBWURFLAPI wurfl_error wurfl_updater_runonce(wurfl_handle hwurfl);
-	if C.wurfl_updater_runonce(w.Wurfl) != C.WURFL_OK {
-		return checkHandleError(w.Wurfl)
+	if C.wurfl_updater_runonce(e.handle) != C.WURFL_OK {
+		return checkHandleError(e.handle)
 	}
 	return nil
 }
@@ -576,18 +702,37 @@ func (w *Wurfl) UpdaterRunonce() error {
 // UpdaterStart - Start the updater, a thread that performs periodic check and update of the wurfl.zip file
 // when a new wurfl.zip is available it is downloaded and engine is switched to use the new wurfl.zip file immediately
 func (w *Wurfl) UpdaterStart() error {
-	//     LIBWURFLAPI wurfl_error wurfl_updater_start(wurfl_handle hwurfl);
-	if C.wurfl_updater_start(w.Wurfl) != C.WURFL_OK {
-		return checkHandleError(w.Wurfl)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	e := w.acquire()
+	if e == nil {
+		return ErrEngineClosed
+	}
+	defer e.release()
+
+	if err := e.updaterStart(); err != nil {
+		return err
 	}
+	w.opts.updaterStart = true
 	return nil
 }
 
 // UpdaterStop - stop the updater
 func (w *Wurfl) UpdaterStop() error {
-	if C.wurfl_updater_stop(w.Wurfl) != C.WURFL_OK {
-		return checkHandleError(w.Wurfl)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	e := w.acquire()
+	if e == nil {
+		return ErrEngineClosed
+	}
+	defer e.release()
+
+	if err := e.updaterStop(); err != nil {
+		return err
 	}
+	w.opts.updaterStart = false
 	return nil
 }
 
@@ -598,51 +743,57 @@ func (w *Wurfl) GetAPIVersion() string {
 
 // GetAllVCaps return all virtual capabilities names
 func (w *Wurfl) GetAllVCaps() []string {
-	var result []string
-
-	eh := C.wurfl_enum_create(w.Wurfl, WurflEnumVirtualCapabilities)
-	defer C.wurfl_enum_destroy(eh)
-
-	for C.wurfl_enum_is_valid(eh) != 0 {
-		cvcapname := C.wurfl_enum_get_name(eh)
-		vcapname := C.GoString(cvcapname)
-		result = append(result, vcapname)
-		C.wurfl_enum_move_next(eh)
+	e := w.acquire()
+	if e == nil {
+		return nil
 	}
+	defer e.release()
 
-	return result
+	return e.enumNames(WurflEnumVirtualCapabilities)
 }
 
 // GetAllCaps return all static capabilities names
 func (w *Wurfl) GetAllCaps() []string {
-	var result []string
-
-	eh := C.wurfl_enum_create(w.Wurfl, WurflEnumStaticCapabilities)
-	defer C.wurfl_enum_destroy(eh)
-
-	for C.wurfl_enum_is_valid(eh) != 0 {
-		ccapname := C.wurfl_enum_get_name(eh)
-		capname := C.GoString(ccapname)
-		result = append(result, capname)
-		C.wurfl_enum_move_next(eh)
+	e := w.acquire()
+	if e == nil {
+		return nil
 	}
+	defer e.release()
 
-	return result
+	return e.enumNames(WurflEnumStaticCapabilities)
 }
 
 // GetInfo - get wurfl.xml info
 func (w *Wurfl) GetInfo() string {
-	return C.GoString(C.wurfl_get_wurfl_info(w.Wurfl))
+	e := w.acquire()
+	if e == nil {
+		return ""
+	}
+	defer e.release()
+
+	return C.GoString(C.wurfl_get_wurfl_info(e.handle))
 }
 
 // GetLastLoadTime - get last wurfl.xml load time
 func (w *Wurfl) GetLastLoadTime() string {
-	return C.GoString(C.wurfl_get_last_load_time_as_string(w.Wurfl))
+	e := w.acquire()
+	if e == nil {
+		return ""
+	}
+	defer e.release()
+
+	return C.GoString(C.wurfl_get_last_load_time_as_string(e.handle))
 }
 
 // GetLastUpdated - get last wurfl.xml update time
 func (w *Wurfl) GetLastUpdated() string {
-	return C.GoString(C.wurfl_get_last_updated(w.Wurfl))
+	e := w.acquire()
+	if e == nil {
+		return ""
+	}
+	defer e.release()
+
+	return C.GoString(C.wurfl_get_last_updated(e.handle))
 }
 
 // GetEngineTarget - Returns a string representing the currently set WURFL Engine Target.
@@ -663,11 +814,29 @@ func (w *Wurfl) GetUserAgentPriority() string {
 	return "OVERRIDE SIDELOADED BROWSER USERAGENT"
 }
 
+// GetImportantHeaderNames returns the names of the headers used by the lookups, as a copy
+// the caller can keep and modify. It is safe for concurrent use.
+func (w *Wurfl) GetImportantHeaderNames() []string {
+	e := w.acquire()
+	if e == nil {
+		return nil
+	}
+	defer e.release()
+
+	return e.headers.Load().Names()
+}
+
 // HasCapability - returns true if the static capability exists in wurfl.zip
 func (w *Wurfl) HasCapability(cap string) bool {
-	ccap := C.CString(cap)
-	ret := C.wurfl_has_capability(w.Wurfl, ccap)
-	C.free(unsafe.Pointer(ccap))
+	e := w.acquire()
+	if e == nil {
+		return false
+	}
+	defer e.release()
+
+	ccap := cString(cap)
+	ret := C.wurfl_has_capability(e.handle, ccap)
+	cFree(ccap)
 	if ret == 0 {
 		return false
 	}
@@ -676,209 +845,267 @@ func (w *Wurfl) HasCapability(cap string) bool {
 
 // HasVirtualCapability - returns true if the virtual cap is available
 func (w *Wurfl) HasVirtualCapability(vcap string) bool {
-	cvcap := C.CString(vcap)
-	ret := C.wurfl_has_virtual_capability(w.Wurfl, cvcap)
-	C.free(unsafe.Pointer(cvcap))
+	e := w.acquire()
+	if e == nil {
+		return false
+	}
+	defer e.release()
+
+	cvcap := cString(vcap)
+	ret := C.wurfl_has_virtual_capability(e.handle, cvcap)
+	cFree(cvcap)
 	if ret == 0 {
 		return false
 	}
 	return true
 }
 
-// LookupDeviceID : lookup by wurfl_ID and return Device handle
-func (w *Wurfl) LookupDeviceID(DeviceID string) (*Device, error) {
+// newDevice returns a Device bound to e; the device keeps e alive until Destroy
+func (e *engine) newDevice() *Device {
 	d := &Device{}
 	// copy wurfl handle into device handle for error handling
-	d.Wurfl = w.Wurfl
+	d.Wurfl = e.handle
 	// copy the caps cache
-	d.capsCStringcache = w.capsCStringcache
-
-	wDeviceID := C.CString(DeviceID)
+	d.capsCStringcache = e.capsCStringcache
+	d.engine = e.retain()
+	e.devices.Add(1)
+	d.usage = e.usage.Load()
+	d.track(e.leaks)
+	return d
+}
 
-	d.Device = C.wurfl_get_device(w.Wurfl, wDeviceID)
-	C.free(unsafe.Pointer(wDeviceID))
+// lookupDone returns d once its lookup has set d.Device, registering the device handle so
+// that closing the engine destroys it, or the error of a failed lookup
+func (d *Device) lookupDone() (*Device, error) {
 	if d.Device == nil {
-		return nil, checkHandleError(w.Wurfl)
+		return nil, d.lookupFailed()
 	}
+	d.engine.addDeviceHandle(d.Device)
 	return d, nil
 }
 
+// lookupFailed returns the error for a failed lookup and drops the reference taken by newDevice
+func (d *Device) lookupFailed() error {
+	err := checkHandleError(d.Wurfl)
+	d.untrack()
+	d.engine.devices.Add(-1)
+	d.engine.unref()
+	d.engine = nil
+	return err
+}
+
+// LookupDeviceID : lookup by wurfl_ID and return Device handle
+func (w *Wurfl) LookupDeviceID(DeviceID string) (*Device, error) {
+	if err := w.limiter.wait(context.Background()); err != nil {
+		return nil, err
+	}
+	defer w.limiter.done()
+
+	e := w.acquire()
+	if e == nil {
+		return nil, ErrEngineClosed
+	}
+	defer e.release()
+	return e.lookupDeviceID(DeviceID)
+}
+
+// lookupDeviceID is LookupDeviceID on e without the limiter. The caller holds a reference
+// on e: it has acquired it, or e is not installed in a Wurfl yet.
+func (e *engine) lookupDeviceID(DeviceID string) (*Device, error) {
+	d := e.newDevice()
+
+	wDeviceID := cString(DeviceID)
+
+	d.Device = C.wurfl_get_device(e.handle, wDeviceID)
+	cFree(wDeviceID)
+	return d.lookupDone()
+}
+
 // LookupUserAgent : lookup up useragent and return Device handle
 func (w *Wurfl) LookupUserAgent(ua string) (*Device, error) {
-	d := &Device{}
-	// copy wurfl handle into device handle for error handling
-	d.Wurfl = w.Wurfl
-	// copy the caps cache
-	d.capsCStringcache = w.capsCStringcache
+	return w.lookupUserAgent(context.Background(), ua)
+}
 
-	wua := C.CString(ua)
+// lookupUserAgent is LookupUserAgent, giving up waiting for the lookup limiter when ctx ends
+func (w *Wurfl) lookupUserAgent(ctx context.Context, ua string) (*Device, error) {
+	if err := w.limiter.wait(ctx); err != nil {
+		return nil, err
+	}
+	defer w.limiter.done()
 
-	d.Device = C.wurfl_lookup_useragent(w.Wurfl, wua)
-	C.free(unsafe.Pointer(wua))
-	if d.Device == nil {
-		return nil, checkHandleError(w.Wurfl)
+	e := w.acquire()
+	if e == nil {
+		return nil, ErrEngineClosed
 	}
-	return d, nil
+	defer e.release()
+	return e.lookupUserAgent(ua)
+}
+
+// lookupUserAgent is LookupUserAgent on e without the limiter, see lookupDeviceID
+func (e *engine) lookupUserAgent(ua string) (*Device, error) {
+	d := e.newDevice()
+
+	wua := cString(ua)
+
+	d.Device = C.wurfl_lookup_useragent(e.handle, wua)
+	cFree(wua)
+	return d.lookupDone()
 }
 
 // LookupRequest : Lookup using Request headers and return Device handle
 func (w *Wurfl) LookupRequest(r *http.Request) (*Device, error) {
+	return w.lookupRequest(context.Background(), r)
+}
 
-	d := &Device{}
-	// copy wurfl handle into device handle for error handling
-	d.Wurfl = w.Wurfl
-	// copy the caps cache
-	d.capsCStringcache = w.capsCStringcache
+// lookupRequest is LookupRequest, giving up waiting for the lookup limiter when ctx ends
+func (w *Wurfl) lookupRequest(ctx context.Context, r *http.Request) (*Device, error) {
+	if err := w.limiter.wait(ctx); err != nil {
+		return nil, err
+	}
+	defer w.limiter.done()
+
+	e := w.acquire()
+	if e == nil {
+		return nil, ErrEngineClosed
+	}
+	defer e.release()
 
 	// create important headers object to pass to lookup
 
-	cih := C.wurfl_important_header_create(w.Wurfl)
+	cih := C.wurfl_important_header_create(e.handle)
 	if cih == nil {
-		return nil, checkHandleError(w.Wurfl)
+		return nil, checkHandleError(e.handle)
 	}
 	defer C.wurfl_important_header_destroy(cih)
 
-	// use important header names loaded during create
-	for i, importantHeaderName := range w.ImportantHeaderNames {
-		// retrieve header value from passed request, if any
-		headerValue := r.Header.Get(importantHeaderName)
-		if len(headerValue) != 0 {
-			// create C strings from header value
-			cheaderValue := C.CString(headerValue)
-
-			// add this header to cih
-			// for header names we use a set of preallocated CStrings with headernames
-			C.wurfl_important_header_set(cih, w.importantHeaderCStringNames[i], cheaderValue)
-			C.free(unsafe.Pointer(cheaderValue))
-		}
-	}
+	// use important header names loaded during create, the values are copied to a pooled
+	// C buffer (see arena.go) and the names are preallocated C strings
+	e.headers.Load().setRequestHeader(cih, r.Header)
 
-	d.Device = C.wurfl_lookup_with_important_header(w.Wurfl, cih)
+	d := e.newDevice()
+	d.Device = C.wurfl_lookup_with_important_header(e.handle, cih)
 
-	if d.Device == nil {
-		return nil, checkHandleError(w.Wurfl)
-	}
-	return d, nil
+	return d.lookupDone()
 }
 
 // LookupDeviceIDWithRequest : lookup by wurfl_ID and request headers and return Device handle
 func (w *Wurfl) LookupDeviceIDWithRequest(DeviceID string, r *http.Request) (*Device, error) {
-	d := &Device{}
-	// copy wurfl handle into device handle for error handling
-	d.Wurfl = w.Wurfl
-	// copy the caps cache
-	d.capsCStringcache = w.capsCStringcache
+	if err := w.limiter.wait(context.Background()); err != nil {
+		return nil, err
+	}
+	defer w.limiter.done()
+
+	e := w.acquire()
+	if e == nil {
+		return nil, ErrEngineClosed
+	}
+	defer e.release()
 
-	wDeviceID := C.CString(DeviceID)
-	defer C.free(unsafe.Pointer(wDeviceID))
+	wDeviceID := cString(DeviceID)
+	defer cFree(wDeviceID)
 
 	// create important headers object to pass to lookup
 
-	cih := C.wurfl_important_header_create(w.Wurfl)
+	cih := C.wurfl_important_header_create(e.handle)
 	if cih == nil {
-		return nil, checkHandleError(w.Wurfl)
+		return nil, checkHandleError(e.handle)
 	}
 	defer C.wurfl_important_header_destroy(cih)
 
-	// use important header names loaded during create
-	for i, importantHeaderName := range w.ImportantHeaderNames {
-		// retrieve header value from passed request, if any
-		headerValue := r.Header.Get(importantHeaderName)
-		if len(headerValue) != 0 {
-			// create C strings from header name and value
-			cheaderValue := C.CString(headerValue)
-
-			// add this header to cih
-			C.wurfl_important_header_set(cih, w.importantHeaderCStringNames[i], cheaderValue)
-			C.free(unsafe.Pointer(cheaderValue))
-		}
-	}
+	// use important header names loaded during create, the values are copied to a pooled
+	// C buffer (see arena.go) and the names are preallocated C strings
+	e.headers.Load().setRequestHeader(cih, r.Header)
 
-	d.Device = C.wurfl_get_device_with_important_header(w.Wurfl, wDeviceID, cih)
-	if d.Device == nil {
-		return nil, checkHandleError(w.Wurfl)
-	}
-	return d, nil
+	d := e.newDevice()
+	d.Device = C.wurfl_get_device_with_important_header(e.handle, wDeviceID, cih)
+	return d.lookupDone()
 }
 
 // LookupWithImportantHeaderMap : Lookup using header values found in IHMap.
-// IHMap must be filled with Wurfl.ImportantHeaderNames and values
+// IHMap must be filled with the Wurfl.GetImportantHeaderNames headers and their values
 func (w *Wurfl) LookupWithImportantHeaderMap(IHMap map[string]string) (*Device, error) {
-	d := &Device{}
-	// copy wurfl handle into device handle for error handling
-	d.Wurfl = w.Wurfl
-	// copy the caps cache
-	d.capsCStringcache = w.capsCStringcache
+	return w.lookupWithImportantHeaderMap(context.Background(), IHMap)
+}
 
+// lookupWithImportantHeaderMap is LookupWithImportantHeaderMap, giving up waiting for the
+// lookup limiter when ctx ends
+func (w *Wurfl) lookupWithImportantHeaderMap(ctx context.Context, IHMap map[string]string) (*Device, error) {
+	if err := w.limiter.wait(ctx); err != nil {
+		return nil, err
+	}
+	defer w.limiter.done()
+
+	e := w.acquire()
+	if e == nil {
+		return nil, ErrEngineClosed
+	}
+	defer e.release()
+	return e.lookupWithImportantHeaderMap(IHMap)
+}
+
+// lookupWithImportantHeaderMap is LookupWithImportantHeaderMap on e without the limiter,
+// see lookupDeviceID
+func (e *engine) lookupWithImportantHeaderMap(IHMap map[string]string) (*Device, error) {
 	// create important headers object to pass to lookup
 
-	cih := C.wurfl_important_header_create(w.Wurfl)
+	cih := C.wurfl_important_header_create(e.handle)
 	if cih == nil {
-		return nil, checkHandleError(w.Wurfl)
+		return nil, checkHandleError(e.handle)
 	}
 	defer C.wurfl_important_header_destroy(cih)
 	// fill it with IHMap entries, using trie for case-insensitive header name lookup
-	for importantHeaderName, headerValue := range IHMap {
-		cheaderName, found := w.importantHeaderTrie.get(importantHeaderName)
-		if !found {
-			continue
-		}
-		cheaderValue := C.CString(headerValue)
-		C.wurfl_important_header_set(cih, cheaderName, cheaderValue)
-		C.free(unsafe.Pointer(cheaderValue))
-	}
+	e.headers.Load().setHeaderMap(cih, IHMap)
 
-	d.Device = C.wurfl_lookup_with_important_header(w.Wurfl, cih)
+	d := e.newDevice()
+	d.Device = C.wurfl_lookup_with_important_header(e.handle, cih)
 
-	if d.Device == nil {
-		return nil, checkHandleError(w.Wurfl)
-	}
-	return d, nil
+	return d.lookupDone()
 }
 
 // LookupDeviceIDWithImportantHeaderMap : Lookup deviceID using header values found in IHMap.
-// IHMap must be filled with Wurfl.ImportantHeaderNames and values
+// IHMap must be filled with the Wurfl.GetImportantHeaderNames headers and their values
 func (w *Wurfl) LookupDeviceIDWithImportantHeaderMap(DeviceID string, IHMap map[string]string) (*Device, error) {
-	d := &Device{}
-	// copy wurfl handle into device handle for error handling
-	d.Wurfl = w.Wurfl
-	// copy the caps cache
-	d.capsCStringcache = w.capsCStringcache
+	if err := w.limiter.wait(context.Background()); err != nil {
+		return nil, err
+	}
+	defer w.limiter.done()
 
-	cDeviceID := C.CString(DeviceID)
-	defer C.free(unsafe.Pointer(cDeviceID))
+	e := w.acquire()
+	if e == nil {
+		return nil, ErrEngineClosed
+	}
+	defer e.release()
+
+	cDeviceID := cString(DeviceID)
+	defer cFree(cDeviceID)
 
 	// create important headers object to pass to lookup
 
-	cih := C.wurfl_important_header_create(w.Wurfl)
+	cih := C.wurfl_important_header_create(e.handle)
 	if cih == nil {
-		return nil, checkHandleError(w.Wurfl)
+		return nil, checkHandleError(e.handle)
 	}
 	defer C.wurfl_important_header_destroy(cih)
 
 	// fill it with IHMap entries, using trie for case-insensitive header name lookup
-	for importantHeaderName, headerValue := range IHMap {
-		cheaderName, found := w.importantHeaderTrie.get(importantHeaderName)
-		if !found {
-			continue
-		}
-		cheaderValue := C.CString(headerValue)
-		C.wurfl_important_header_set(cih, cheaderName, cheaderValue)
-		C.free(unsafe.Pointer(cheaderValue))
-	}
+	e.headers.Load().setHeaderMap(cih, IHMap)
 
-	d.Device = C.wurfl_get_device_with_important_header(w.Wurfl, cDeviceID, cih)
-	if d.Device == nil {
-		return nil, checkHandleError(w.Wurfl)
-	}
-	return d, nil
+	d := e.newDevice()
+	d.Device = C.wurfl_get_device_with_important_header(e.handle, cDeviceID, cih)
+	return d.lookupDone()
 }
 
 // IsUserAgentFrozen : returns true if a UserAgent is frozen
 func (w *Wurfl) IsUserAgentFrozen(ua string) bool {
-	wua := C.CString(ua)
-	ret := C.wurfl_is_ua_frozen(w.Wurfl, wua)
-	C.free(unsafe.Pointer(wua))
+	e := w.acquire()
+	if e == nil {
+		return false
+	}
+	defer e.release()
+
+	wua := cString(ua)
+	ret := C.wurfl_is_ua_frozen(e.handle, wua)
+	cFree(wua)
 	if ret == 0 {
 		return false
 	}
@@ -887,27 +1114,23 @@ func (w *Wurfl) IsUserAgentFrozen(ua string) bool {
 
 // GetHeaderQuality returns an indicator of how many sec-ch-ua headers are present in the request
 func (w *Wurfl) GetHeaderQuality(r *http.Request) (HeaderQuality, error) {
+	e := w.acquire()
+	if e == nil {
+		return HeaderQualityNone, ErrEngineClosed
+	}
+	defer e.release()
+
 	// create important headers object to pass to lookup
-	cih := C.wurfl_important_header_create(w.Wurfl)
+	cih := C.wurfl_important_header_create(e.handle)
 	if cih == nil {
-		return HeaderQualityNone, checkHandleError(w.Wurfl)
+		return HeaderQualityNone, checkHandleError(e.handle)
 
 	}
 	defer C.wurfl_important_header_destroy(cih)
 
-	// use important header names loaded during create
-	for i, importantHeaderName := range w.ImportantHeaderNames {
-		// retrieve header value from passed request, if any
-		headerValue := r.Header.Get(importantHeaderName)
-		if len(headerValue) != 0 {
-			// create C strings from header name and value
-			cheaderValue := C.CString(headerValue)
-
-			// add this header to cih
-			C.wurfl_important_header_set(cih, w.importantHeaderCStringNames[i], cheaderValue)
-			C.free(unsafe.Pointer(cheaderValue))
-		}
-	}
+	// use important header names loaded during create, the values are copied to a pooled
+	// C buffer (see arena.go) and the names are preallocated C strings
+	e.headers.Load().setRequestHeader(cih, r.Header)
 
 	hq := C.wurfl_important_header_uach_quality(cih)
 	return HeaderQuality(hq), nil
@@ -919,6 +1142,13 @@ func (w *Wurfl) GetHeaderQuality(r *http.Request) (HeaderQuality, error) {
 
 // GetUserAgent Get default UserAgent of matched device (might be different from UA passed to lookup)
 func (d *Device) GetUserAgent() (string, error) {
+	e, err := d.acquire()
+	if err != nil {
+		return "", err
+	}
+	defer e.release()
+	defer runtime.KeepAlive(d)
+
 	cua := C.wurfl_device_get_useragent(d.Device)
 	if cua == nil {
 		return "", checkHandleError(d.Wurfl)
@@ -929,6 +1159,13 @@ func (d *Device) GetUserAgent() (string, error) {
 
 // GetOriginalUserAgent Get the original userAgent of matched device (the one passed to lookup)
 func (d *Device) GetOriginalUserAgent() (string, error) {
+	e, err := d.acquire()
+	if err != nil {
+		return "", err
+	}
+	defer e.release()
+	defer runtime.KeepAlive(d)
+
 	oua := C.wurfl_device_get_original_useragent(d.Device)
 	if oua == nil {
 		return "", checkHandleError(d.Wurfl)
@@ -939,6 +1176,13 @@ func (d *Device) GetOriginalUserAgent() (string, error) {
 
 // GetNormalizedUserAgent Get the Normalized (processed by wurfl api) userAgent ( Only for internal use/tooling)
 func (d *Device) GetNormalizedUserAgent() (string, error) {
+	e, err := d.acquire()
+	if err != nil {
+		return "", err
+	}
+	defer e.release()
+	defer runtime.KeepAlive(d)
+
 	nua := C.wurfl_device_get_normalized_useragent(d.Device)
 	if nua == nil {
 		return "", checkHandleError(d.Wurfl)
@@ -949,6 +1193,13 @@ func (d *Device) GetNormalizedUserAgent() (string, error) {
 
 // GetDeviceID Get wurfl_id string from device handle
 func (d *Device) GetDeviceID() (string, error) {
+	e, err := d.acquire()
+	if err != nil {
+		return "", err
+	}
+	defer e.release()
+	defer runtime.KeepAlive(d)
+
 	cdeviceid := C.wurfl_device_get_id(d.Device)
 	if cdeviceid == nil {
 		return "", checkHandleError(d.Wurfl)
@@ -959,16 +1210,41 @@ func (d *Device) GetDeviceID() (string, error) {
 
 // GetRootID - Retrieve the root device id of this device.
 func (d *Device) GetRootID() string {
-	return C.GoString(C.wurfl_device_get_root_id(d.Device))
+	e, err := d.acquire()
+	if err != nil {
+		return ""
+	}
+	defer e.release()
+	defer runtime.KeepAlive(d)
+
+	croot := C.wurfl_device_get_root_id(d.Device)
+	if testHookDeviceCall != nil {
+		testHookDeviceCall()
+	}
+	return C.GoString(croot)
 }
 
 // GetParentID - Retrieve the parent device id of this device.
 func (d *Device) GetParentID() string {
+	e, err := d.acquire()
+	if err != nil {
+		return ""
+	}
+	defer e.release()
+	defer runtime.KeepAlive(d)
+
 	return C.GoString(C.wurfl_device_get_parent_id(d.Device))
 }
 
 // IsRoot - true if device is device root
 func (d *Device) IsRoot() bool {
+	e, err := d.acquire()
+	if err != nil {
+		return false
+	}
+	defer e.release()
+	defer runtime.KeepAlive(d)
+
 	if C.wurfl_device_is_actual_device_root(d.Device) == 0 {
 		return false
 	}
@@ -978,11 +1254,19 @@ func (d *Device) IsRoot() bool {
 // GetCapability Get a single Capability
 // Deprecated: GetCapability is deprecated. Use GetStaticCap instead.
 func (d *Device) GetCapability(cap string) string {
+	e, err := d.acquire()
+	if err != nil {
+		return ""
+	}
+	defer e.release()
+	defer runtime.KeepAlive(d)
+
+	d.recordStatic(cap)
 	ccap, found := d.capsCStringcache[cap]
 	if !found {
 		// non existing capability?
-		ccap = C.CString(cap)
-		defer C.free(unsafe.Pointer(ccap))
+		ccap = cString(cap)
+		defer cFree(ccap)
 	}
 
 	ccapvalue := C.wurfl_device_get_capability(d.Device, ccap)
@@ -994,11 +1278,19 @@ func (d *Device) GetCapability(cap string) string {
 // GetStaticCap Get a single static cap using new C.wurfl_device_get_static_cap()
 // that returns error
 func (d *Device) GetStaticCap(cap string) (string, error) {
+	e, err := d.acquire()
+	if err != nil {
+		return "", err
+	}
+	defer e.release()
+	defer runtime.KeepAlive(d)
+
+	d.recordStatic(cap)
 	ccap, found := d.capsCStringcache[cap]
 	if !found {
 		// non existing capability?
-		ccap = C.CString(cap)
-		defer C.free(unsafe.Pointer(ccap))
+		ccap = cString(cap)
+		defer cFree(ccap)
 	}
 	retCode := C.wurfl_error(0)
 	ccapvalue := C.wurfl_device_get_static_cap(d.Device, ccap, &retCode)
@@ -1013,11 +1305,19 @@ func (d *Device) GetStaticCap(cap string) (string, error) {
 // GetCapabilityAsInt gets a single static capability value that has a int type
 // It returns an error if the requested static capability is not a numeric one (ie: brand_name)
 func (d *Device) GetCapabilityAsInt(cap string) (int, error) {
+	e, err := d.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer e.release()
+	defer runtime.KeepAlive(d)
+
+	d.recordStatic(cap)
 	ccap, found := d.capsCStringcache[cap]
 	if !found {
 		// non existing capability?
-		ccap = C.CString(cap)
-		C.free(unsafe.Pointer(ccap))
+		ccap = cString(cap)
+		defer cFree(ccap)
 	}
 	cErr := C.wurfl_error(0)
 	ccapvalue := C.wurfl_device_get_static_cap_as_int(d.Device, ccap, &cErr)
@@ -1033,14 +1333,22 @@ func (d *Device) GetCapabilityAsInt(cap string) (int, error) {
 // GetCapabilities Get a list of Static Capabilities
 // Deprecated: GetCapabilities is deprecated. Use GetStaticCaps instead.
 func (d *Device) GetCapabilities(caps []string) map[string]string {
+	e, err := d.acquire()
+	if err != nil {
+		return map[string]string{}
+	}
+	defer e.release()
+	defer runtime.KeepAlive(d)
+
 	result := make(map[string]string, len(caps))
 
 	for i := 0; i < len(caps); i++ {
+		d.recordStatic(caps[i])
 		ccap, found := d.capsCStringcache[caps[i]]
 		if !found {
 			// non existing capability?
-			ccap = C.CString(caps[i])
-			defer C.free(unsafe.Pointer(ccap))
+			ccap = cString(caps[i])
+			defer cFree(ccap)
 		}
 
 		ccapvalue := C.wurfl_device_get_capability(d.Device, ccap)
@@ -1053,15 +1361,23 @@ func (d *Device) GetCapabilities(caps []string) map[string]string {
 
 // GetStaticCaps Get a list of Static Capabilities
 func (d *Device) GetStaticCaps(caps []string) (map[string]string, error) {
+	e, err := d.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer e.release()
+	defer runtime.KeepAlive(d)
+
 	var errMsg *C.char
 	result := make(map[string]string, len(caps))
 
 	for i := 0; i < len(caps); i++ {
+		d.recordStatic(caps[i])
 		ccap, found := d.capsCStringcache[caps[i]]
 		if !found {
 			// non existing capability?
-			ccap = C.CString(caps[i])
-			defer C.free(unsafe.Pointer(ccap))
+			ccap = cString(caps[i])
+			defer cFree(ccap)
 		}
 
 		retCode := C.wurfl_error(0)
@@ -1085,11 +1401,19 @@ func (d *Device) GetStaticCaps(caps []string) (map[string]string, error) {
 // GetVirtualCapability Get Virtual Capability
 // Deprecated: GetVirtualCapability is deprecated. Use GetVirtualCap instead.
 func (d *Device) GetVirtualCapability(vcap string) string {
+	e, err := d.acquire()
+	if err != nil {
+		return ""
+	}
+	defer e.release()
+	defer runtime.KeepAlive(d)
+
+	d.recordVirtual(vcap)
 	cvcap, found := d.capsCStringcache[vcap]
 	if !found {
 		// non existing capability?
-		cvcap = C.CString(vcap)
-		defer C.free(unsafe.Pointer(cvcap))
+		cvcap = cString(vcap)
+		defer cFree(cvcap)
 	}
 
 	cvcapvalue := C.wurfl_device_get_virtual_capability(d.Device, cvcap)
@@ -1101,11 +1425,19 @@ func (d *Device) GetVirtualCapability(vcap string) string {
 // GetVirtualCap Get Virtual Cap with new C.wurfl_device_get_virtual_cap()
 // that manages errors
 func (d *Device) GetVirtualCap(vcap string) (string, error) {
+	e, err := d.acquire()
+	if err != nil {
+		return "", err
+	}
+	defer e.release()
+	defer runtime.KeepAlive(d)
+
+	d.recordVirtual(vcap)
 	cvcap, found := d.capsCStringcache[vcap]
 	if !found {
 		// non existing capability?
-		cvcap = C.CString(vcap)
-		defer C.free(unsafe.Pointer(cvcap))
+		cvcap = cString(vcap)
+		defer cFree(cvcap)
 	}
 	retCode := C.wurfl_error(0)
 	cvcapvalue := C.wurfl_device_get_virtual_cap(d.Device, cvcap, &retCode)
@@ -1121,12 +1453,20 @@ func (d *Device) GetVirtualCap(vcap string) (string, error) {
 // GetVirtualCapabilityAsInt gets a single virtual capability value that has a int type
 // It returns an error if the requested virtual capability is not a numeric one (ie: brand_name)
 func (d *Device) GetVirtualCapabilityAsInt(vcap string) (int, error) {
+	e, err := d.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer e.release()
+	defer runtime.KeepAlive(d)
+
+	d.recordVirtual(vcap)
 	// the "C" vcap name
 	cvcap, found := d.capsCStringcache[vcap]
 	if !found {
 		// non existing capability?
-		cvcap = C.CString(vcap)
-		defer C.free(unsafe.Pointer(cvcap))
+		cvcap = cString(vcap)
+		defer cFree(cvcap)
 	}
 	cErr := C.wurfl_error(0)
 	ccapvalue := C.wurfl_device_get_virtual_cap_as_int(d.Device, cvcap, &cErr)
@@ -1142,14 +1482,22 @@ func (d *Device) GetVirtualCapabilityAsInt(vcap string) (int, error) {
 // GetVirtualCapabilities Get a list of Virtual Capabilities
 // Deprecated: GetVirtualCapabilities is deprecated. Use GetVirtualCaps instead.
 func (d *Device) GetVirtualCapabilities(caps []string) map[string]string {
+	e, err := d.acquire()
+	if err != nil {
+		return map[string]string{}
+	}
+	defer e.release()
+	defer runtime.KeepAlive(d)
+
 	result := make(map[string]string)
 
 	for i := 0; i < len(caps); i++ {
+		d.recordVirtual(caps[i])
 		ccap, found := d.capsCStringcache[caps[i]]
 		if !found {
 			// non existing capability?
-			ccap = C.CString(caps[i])
-			defer C.free(unsafe.Pointer(ccap))
+			ccap = cString(caps[i])
+			defer cFree(ccap)
 		}
 
 		ccapvalue := C.wurfl_device_get_virtual_capability(d.Device, ccap)
@@ -1162,15 +1510,23 @@ func (d *Device) GetVirtualCapabilities(caps []string) map[string]string {
 
 // GetVirtualCaps Get a list of Virtual Capabilities
 func (d *Device) GetVirtualCaps(caps []string) (map[string]string, error) {
+	e, err := d.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer e.release()
+	defer runtime.KeepAlive(d)
+
 	var errMsg *C.char
 	result := make(map[string]string, len(caps))
 
 	for i := 0; i < len(caps); i++ {
+		d.recordVirtual(caps[i])
 		ccap, found := d.capsCStringcache[caps[i]]
 		if !found {
 			// non existing capability?
-			ccap = C.CString(caps[i])
-			defer C.free(unsafe.Pointer(ccap))
+			ccap = cString(caps[i])
+			defer cFree(ccap)
 		}
 
 		retCode := C.wurfl_error(0)
@@ -1193,6 +1549,12 @@ func (d *Device) GetVirtualCaps(caps []string) (map[string]string, error) {
 
 // GetMatchType Get type of Match occurred in lookup
 func (d *Device) GetMatchType() int {
+	e, err := d.acquire()
+	if err != nil {
+		return WurflMatchTypeNone
+	}
+	defer e.release()
+	defer runtime.KeepAlive(d)
 
 	cmtype := C.wurfl_device_get_match_type(d.Device)
 	mtype := int(cmtype)
@@ -1203,10 +1565,32 @@ func (d *Device) GetMatchType() int {
 // Destroy device handle, should be called when when device attributes
 // are not needed anymore
 func (d *Device) Destroy() {
-	if d.Device != nil {
+	if d == nil || d.engine == nil {
+		return
+	}
+	// once the engine is closed, the device handle has been destroyed by engine.close
+	if e, err := d.acquire(); err == nil {
+		e.removeDeviceHandle(d.Device)
 		C.wurfl_device_destroy(d.Device)
-		d.Device = nil
+		e.release()
+	}
+	d.Device = nil
+	d.untrack()
+	d.engine.devices.Add(-1)
+	d.engine.unref()
+	d.engine = nil
+}
+
+// acquire starts a call on the engine of d, the call ends with release. It fails with
+// ErrEngineClosed once the engine has been destroyed, and with ErrInvalidHandle once d has.
+func (d *Device) acquire() (*engine, error) {
+	if d.engine == nil {
+		return nil, checkHandleError(nil)
 	}
+	if !d.engine.tryAcquire() {
+		return nil, ErrEngineClosed
+	}
+	return d.engine, nil
 }
 
 // ORTB2GetDevicetype returns the ORTB2 device type based on WURFL capabilities.
@@ -1226,6 +1610,10 @@ func (d *Device) ORTB2GetDevicetype() (int, error) {
 	isConsole, errConsole := d.GetStaticCap("is_console")
 	physicalFormFactor, errPFF := d.GetStaticCap("physical_form_factor")
 	formFactor, errFF := d.GetVirtualCap("form_factor")
+	if errors.Is(errOtt, ErrInvalidHandle) {
+		// destroyed device or closed engine
+		return -1, errOtt
+	}
 	if errOtt != nil || errConsole != nil || errPFF != nil || errFF != nil {
 		return -1, errors.New("ORTB2GetDevicetype: " + errMissingCaps)
 	}
@@ -1265,8 +1653,13 @@ func (d *Device) ORTB2GetDevicetype() (int, error) {
 
 // GetAllDeviceIds returns a slice containing all wurfl_id present in wurfl.zip
 func (w *Wurfl) GetAllDeviceIds() []string {
+	e := w.acquire()
+	if e == nil {
+		return nil
+	}
+	defer e.release()
 
-	eh := C.wurfl_enum_create(w.Wurfl, WurflEnumWurflID)
+	eh := C.wurfl_enum_create(e.handle, WurflEnumWurflID)
 	elen := C.wurfl_enum_len(eh)
 	var result = make([]string, 0, elen)
 
@@ -1307,14 +1700,20 @@ func (w *Wurfl) GetAllDeviceIds() []string {
 
 // GoStringToCStringAndFree converts a Go string to a C string and frees the memory.
 func GoStringToCStringAndFree(capname string) *C.char {
-	ccap := C.CString(capname)
-	C.free(unsafe.Pointer(ccap))
+	ccap := cString(capname)
+	cFree(ccap)
 	return ccap
 }
 
 // GoStringToCStringUsingMap returns a C string pointer for the given capability name using a cached map.
 func (w *Wurfl) GoStringToCStringUsingMap(capname string) *C.char {
-	return w.capsCStringcache[capname]
+	e := w.acquire()
+	if e == nil {
+		return nil
+	}
+	defer e.release()
+
+	return e.capsCStringcache[capname]
 }
 
 // BenchmarkableTrieGet creates an isolated headerTrie populated with the given header names
@@ -1324,7 +1723,7 @@ func (w *Wurfl) GoStringToCStringUsingMap(capname string) *C.char {
 func BenchmarkableTrieGet(headerNames []string) func(string) unsafe.Pointer {
 	var trie headerTrie
 	for _, name := range headerNames {
-		cname := C.CString(name)
+		cname := cString(name)
 		trie.set(name, cname)
 	}
 	return func(key string) unsafe.Pointer {
@@ -1344,7 +1743,7 @@ func BenchmarkableTrieGet(headerNames []string) func(string) unsafe.Pointer {
 func BenchmarkableMapGet(headerNames []string) func(string) unsafe.Pointer {
 	m := make(map[string]*C.char, len(headerNames))
 	for _, name := range headerNames {
-		cname := C.CString(name)
+		cname := cString(name)
 		m[strings.ToLower(name)] = cname
 	}
 	return func(key string) unsafe.Pointer {
@@ -1356,34 +1755,47 @@ func BenchmarkableMapGet(headerNames []string) func(string) unsafe.Pointer {
 	}
 }
 
-// CompareVersions Returns 0 if v1 == v2, -1 if v1 < v2, and 1 if v1 > v2.
-func CompareVersions(v1, v2 string) int {
-	parts1 := strings.Split(v1, ".")
-	parts2 := strings.Split(v2, ".")
-
-	// Compare each part
-	for i := 0; i < 4; i++ {
-		// Convert string parts to integers for comparison
-		num1, err := strconv.Atoi(parts1[i])
-		if err != nil {
-			fmt.Printf("Error converting version part '%s' to integer: %s\n", parts1[i], err)
-			return 0
-		}
-
-		num2, err := strconv.Atoi(parts2[i])
-		if err != nil {
-			fmt.Printf("Error converting version part '%s' to integer: %s\n", parts2[i], err)
-			return 0
+// BenchmarkableHeaderValuesCString returns a function copying values to C strings with a
+// C.CString/C.free pair per value, as the header lookups did before the arena (see arena.go).
+// The function returns the number of C allocations it made.
+func BenchmarkableHeaderValuesCString(values []string) func() int {
+	return func() int {
+		for _, v := range values {
+			cvalue := C.CString(v)
+			C.free(unsafe.Pointer(cvalue))
 		}
-
-		// Compare parts
-		if num1 > num2 {
-			return 1
-		} else if num1 < num2 {
-			return -1
+		return len(values)
+	}
+}
+
+// BenchmarkableHeaderValuesArena returns a function copying values to a pooled headerArena,
+// as the header lookups do. The function returns the number of C allocations it made, 0
+// once the pooled arena is large enough.
+func BenchmarkableHeaderValuesArena(values []string) func() int {
+	return func() int {
+		mallocs := 0
+		a := getHeaderArena()
+		buf := a.buf
+		for _, v := range values {
+			a.cString(v)
+			if a.buf != buf {
+				mallocs++
+				buf = a.buf
+			}
 		}
+		a.release()
+		return mallocs
 	}
+}
 
-	// If all parts are equal
-	return 0
+// CompareVersions Returns 0 if v1 == v2, -1 if v1 < v2, and 1 if v1 > v2.
+// Versions that cannot be parsed compare as equal.
+//
+// Deprecated: use CompareVersionStrings, which reports malformed versions as errors.
+func CompareVersions(v1, v2 string) int {
+	result, err := CompareVersionStrings(v1, v2)
+	if err != nil {
+		return 0
+	}
+	return result
 }